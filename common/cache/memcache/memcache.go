@@ -1,18 +1,44 @@
 package memcache
 
 import (
+	"errors"
 	"github.com/bradfitz/gomemcache/memcache"
 	"RabbitmqConnectionDispatcher/config/bootstrap"
+	"sync"
 )
 
+var ErrClosed = errors.New("memcache client closed")
+
 var MC *memcache.Client
+var mu sync.RWMutex
 func init() {
 	config := bootstrap.App.AppConfig.Map("memcache")
 	MC = memcache.New(config["host"])
 }
 
+func client() (*memcache.Client, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if MC == nil {
+		return nil, ErrClosed
+	}
+	return MC, nil
+}
+
+//gomemcache没有Close, 丢弃client后空闲连接随client一起释放, 之后的调用返回ErrClosed
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	MC = nil
+	return nil
+}
+
 func GetString(key string) (string, error) {
-	item, err := MC.Get(key)
+	mc, err := client()
+	if err != nil {
+		return "", err
+	}
+	item, err := mc.Get(key)
 	if err != nil {
 		return "", err
 	}
@@ -20,7 +46,11 @@ func GetString(key string) (string, error) {
 }
 
 func Get(key string) ([]byte, error) {
-	item, err := MC.Get(key)
+	mc, err := client()
+	if err != nil {
+		return nil, err
+	}
+	item, err := mc.Get(key)
 	if err != nil {
 		return nil, err
 	}
@@ -28,14 +58,22 @@ func Get(key string) ([]byte, error) {
 }
 
 func SetString(key, value string) error {
-	return MC.Set(&memcache.Item{
+	mc, err := client()
+	if err != nil {
+		return err
+	}
+	return mc.Set(&memcache.Item{
 		Key: key,
 		Value: []byte(value),
 	})
 }
 
 func SetBytes(key string, value []byte, expired int32) error {
-	return MC.Set(&memcache.Item{
+	mc, err := client()
+	if err != nil {
+		return err
+	}
+	return mc.Set(&memcache.Item{
 		Key: key,
 		Value: value,
 		Expiration: expired,
@@ -43,12 +81,20 @@ func SetBytes(key string, value []byte, expired int32) error {
 }
 
 func CompareAndSwap(key, value string) error {
-	return MC.CompareAndSwap(&memcache.Item{
+	mc, err := client()
+	if err != nil {
+		return err
+	}
+	return mc.CompareAndSwap(&memcache.Item{
 		Key: key,
 		Value: []byte(value),
 	})
 }
 
 func Delete(key string) error {
-	return MC.Delete(key)
+	mc, err := client()
+	if err != nil {
+		return err
+	}
+	return mc.Delete(key)
 }
\ No newline at end of file
@@ -37,6 +37,10 @@ func init() {
 	Pool = pool
 }
 
+func Close() error {
+	return Pool.Close()
+}
+
 func Ping() error {
 	c := Pool.Get()
 	defer c.Close()
@@ -139,3 +143,24 @@ func SetMapAndExpire(key string, m map[string]string, second int) error {
 }
 
 
+
+//INCR并设置过期时间, 返回增加后的值
+func Incr(key string, seconds int) (int, error) {
+	c := Pool.Get()
+	defer c.Close()
+	c.Send("multi")
+	c.Send("incr", key)
+	c.Send("expire", key, seconds)
+	res, err := redis.Values(c.Do("exec"))
+	if err != nil {
+		return NIL, err
+	}
+	return redis.Int(res[0], nil)
+}
+
+func Del(key string) error {
+	c := Pool.Get()
+	defer c.Close()
+	_, err := c.Do("del", key)
+	return err
+}
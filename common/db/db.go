@@ -83,3 +83,10 @@ func scheduleDebug(f func()) {
 func GetDBConnection() (db *sql.DB) {
 	return sharedDB
 }
+
+func Close() error {
+	if sharedDB == nil {
+		return nil
+	}
+	return sharedDB.Close()
+}
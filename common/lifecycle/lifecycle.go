@@ -0,0 +1,117 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"RabbitmqConnectionDispatcher/common/log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Hook is a subsystem of the application, OnStart and OnStop may be nil
+type Hook struct {
+	Name    string
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+//按注册顺序启动, 按相反顺序停止, 所以被依赖的子系统需要先注册
+type Lifecycle struct {
+	mu      sync.Mutex
+	hooks   []Hook
+	started int
+	timeout time.Duration
+}
+
+// New returns a Lifecycle that gives the whole Stop at most timeout
+func New(timeout time.Duration) *Lifecycle {
+	return &Lifecycle{timeout: timeout}
+}
+
+func (l *Lifecycle) Append(hook Hook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, hook)
+}
+
+// Start runs the OnStart hooks in order, if one fails the started hooks are stopped
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.started < len(l.hooks) {
+		hook := l.hooks[l.started]
+		if hook.OnStart != nil {
+			log.Logger.Info("starting ", hook.Name)
+			if err := hook.OnStart(ctx); err != nil {
+				err = fmt.Errorf("start %s: %s", hook.Name, err.Error())
+				stopCtx, cancel := context.WithTimeout(context.Background(), l.timeout)
+				defer cancel()
+				if err1 := l.stop(stopCtx); err1 != nil {
+					log.Logger.Error(err1.Error())
+				}
+				return err
+			}
+		}
+		l.started++
+	}
+	return nil
+}
+
+// Stop runs the OnStop hooks of the started subsystems in reverse order,
+// a hook still running when ctx is done is abandoned
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stop(ctx)
+}
+
+//no-lock
+func (l *Lifecycle) stop(ctx context.Context) error {
+	var errs []string
+	for ; l.started > 0; l.started-- {
+		hook := l.hooks[l.started-1]
+		if hook.OnStop == nil {
+			continue
+		}
+		log.Logger.Info("stopping ", hook.Name)
+		done := make(chan error, 1)
+		go func() {
+			done <- hook.OnStop(ctx)
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("stop %s: %s", hook.Name, err.Error()))
+			}
+		case <-ctx.Done():
+			errs = append(errs, fmt.Sprintf("stop %s: %s", hook.Name, ctx.Err().Error()))
+			l.started = 0
+			return fmt.Errorf("%s", strings.Join(errs, "; "))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Run starts the application and blocks until SIGINT, SIGTERM or SIGQUIT,
+// then stops it within the timeout given to New
+func (l *Lifecycle) Run() error {
+	if err := l.Start(context.Background()); err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	s := <-signals
+	signal.Stop(signals)
+	log.Logger.Info("receive signal ", s.String(), ", shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	return l.Stop(ctx)
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/spf13/viper"
 	"strings"
 	"log"
+	"os"
 )
 
 var (
@@ -16,6 +17,8 @@ var (
 	ENV_CONFIG_PREFIX = `DB`
 	ENV_CONFIG_NAME = `env`
 	CONFIG_PATH = `./`
+	// overrides CONFIG_PATH, e.g. APP_CONFIG_PATH=.. go test ./rabbitmq
+	CONFIG_PATH_ENV = `APP_CONFIG_PATH`
 	CONFIG_FILE_TYPE = `yaml`
 )
 
@@ -48,7 +51,7 @@ func (app *Application) loadEnvConfig() {
 	envConfig.SetEnvKeyReplacer(REPLACER)
 	envConfig.AutomaticEnv()
 	envConfig.SetConfigName(ENV_CONFIG_NAME)
-	envConfig.AddConfigPath(CONFIG_PATH)
+	envConfig.AddConfigPath(configPath())
 	envConfig.SetConfigType(CONFIG_FILE_TYPE)
 	if err = envConfig.ReadInConfig(); err != nil {
 		panic(err)
@@ -66,7 +69,7 @@ func (app *Application) loadAppConfig() {
 	appConfig.SetEnvKeyReplacer(REPLACER)
 	appConfig.AutomaticEnv()
 	appConfig.SetConfigName(APP_CONFIG_NAME)
-	appConfig.AddConfigPath(CONFIG_PATH)
+	appConfig.AddConfigPath(configPath())
 	appConfig.SetConfigType(CONFIG_FILE_TYPE)
 	if err = appConfig.ReadInConfig(); err != nil {
 		panic(err)
@@ -74,6 +77,13 @@ func (app *Application) loadAppConfig() {
 	app.AppConfig = Config(*appConfig)
 }
 
+func configPath() string {
+	if path := os.Getenv(CONFIG_PATH_ENV); path != "" {
+		return path
+	}
+	return CONFIG_PATH
+}
+
 func (app *Application) loadENV() {
 	var APPENV string
 	var envConfig viper.Viper
@@ -117,4 +127,10 @@ func (config *Config) Map(key string) map[string]string {
 	var viperConfig viper.Viper
 	viperConfig = viper.Viper(*config)
 	return viperConfig.GetStringMapString(fmt.Sprintf("%s.%s", App.ENV, key))
-}
\ No newline at end of file
+}
+// UnmarshalKey: decode the value of key into rawVal
+func (config *Config) UnmarshalKey(key string, rawVal interface{}) error {
+	var viperConfig viper.Viper
+	viperConfig = viper.Viper(*config)
+	return viperConfig.UnmarshalKey(fmt.Sprintf("%s.%s", App.ENV, key), rawVal)
+}
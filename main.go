@@ -1,11 +1,19 @@
 package RabbitmqConnectionDispatcher
 
 import (
-	"log"
+	"context"
 	"net/http"
 	"strconv"
+	"time"
+	"RabbitmqConnectionDispatcher/common/cache/memcache"
+	"RabbitmqConnectionDispatcher/common/cache/redis"
+	"RabbitmqConnectionDispatcher/common/db"
+	"RabbitmqConnectionDispatcher/common/lifecycle"
+	"RabbitmqConnectionDispatcher/common/log"
 	"RabbitmqConnectionDispatcher/config/bootstrap"
 	"RabbitmqConnectionDispatcher/rabbitmq"
+	"RabbitmqConnectionDispatcher/trace"
+	"RabbitmqConnectionDispatcher/worker"
 )
 
 func main() {
@@ -16,6 +24,11 @@ func main() {
 	mpcpc, _ := strconv.Atoi(poolConfig["max_producer_channel_pre_conn"])
 	mccpc, _ := strconv.Atoi(poolConfig["max_consumer_channel_pre_conn"])
 
+	var rateLimit rabbitmq.RateLimitConfig
+	if err := bootstrap.App.AppConfig.UnmarshalKey("rabbitmq.rate_limit", &rateLimit); err != nil {
+		log.Logger.Error(err)
+	}
+
 	config := &rabbitmq.Config{
 		Host:     rmqConfig["host"],
 		Port:     port,
@@ -25,15 +38,116 @@ func main() {
 		MaxConnectionsInPool: mc,
 		MaxProducerChannelPerConn: mpcpc,
 		MaxConcusmerChannelPerConn: mccpc,
+		PublishRateLimit: rateLimit.Global,
+		ExchangeRateLimits: rateLimit.Exchanges,
+		ProducerRateLimits: rateLimit.Producers,
+	}
+	var topologyConfig struct {
+		Verify bool
+		rabbitmq.TopologyConfig `mapstructure:",squash"`
+	}
+	if err := bootstrap.App.AppConfig.UnmarshalKey("rabbitmq.topology", &topologyConfig); err != nil {
+		log.Logger.Error(err)
+	}
+	topology, err := rabbitmq.NewTopology(topologyConfig.TopologyConfig)
+	if err != nil {
+		log.Logger.Fatal(err)
+	}
+
+	var monitorConfig struct {
+		Interval int
+		Queues   []rabbitmq.QueueThreshold
+	}
+	if err := bootstrap.App.AppConfig.UnmarshalKey("rabbitmq.monitor", &monitorConfig); err != nil {
+		log.Logger.Error(err)
+	}
+	monitor := rabbitmq.NewQueueMonitor(time.Duration(monitorConfig.Interval) * time.Second, monitorConfig.Queues...)
+
+	timeout := bootstrap.App.AppConfig.Int("shutdown_timeout")
+	if timeout <= 0 {
+		timeout = 30
 	}
-	rabbitmq.InitPool(config)
-	go Receiver()
+	app := lifecycle.New(time.Duration(timeout) * time.Second)
+
+	//按依赖顺序注册, 停止时顺序相反
+	app.Append(lifecycle.Hook{
+		Name: "redis",
+		OnStop: func(ctx context.Context) error {
+			return redis.Close()
+		},
+	})
+	app.Append(lifecycle.Hook{
+		Name: "memcache",
+		OnStop: func(ctx context.Context) error {
+			return memcache.Close()
+		},
+	})
+	app.Append(lifecycle.Hook{
+		Name: "db",
+		OnStop: func(ctx context.Context) error {
+			return db.Close()
+		},
+	})
+	app.Append(lifecycle.Hook{
+		Name: "rabbitmq pool",
+		OnStart: func(ctx context.Context) error {
+			rabbitmq.InitPool(config)
+			if topologyConfig.Verify {
+				_, err := topology.Verify()
+				return err
+			}
+			return topology.Declare()
+		},
+		OnStop: func(ctx context.Context) error {
+			return rabbitmq.ClosePool()
+		},
+	})
+	app.Append(lifecycle.Hook{
+		Name: "queue monitor",
+		OnStart: func(ctx context.Context) error {
+			if monitorConfig.Interval > 0 && len(monitorConfig.Queues) > 0 {
+				monitor.Start()
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			monitor.Stop()
+			return nil
+		},
+	})
+	app.Append(lifecycle.Hook{
+		Name: "worker",
+		OnStop: worker.Shutdown,
+	})
+	app.Append(lifecycle.Hook{
+		Name: "consumers",
+		OnStart: func(ctx context.Context) error {
+			go Receiver()
+			return nil
+		},
+		OnStop: rabbitmq.ShutdownConsumers,
+	})
 
-	port := bootstrap.App.AppConfig.String("port")
-	go func(p string) {
-		http.ListenAndServe(":"+p, trace.InitRouter())
-	}(port)
+	server := &http.Server{ Addr: ":" + bootstrap.App.AppConfig.String("port") }
+	app.Append(lifecycle.Hook{
+		Name: "http server",
+		OnStart: func(ctx context.Context) error {
+			router := trace.InitRouter()
+			rabbitmq.RegisterAdminRoutes(router)
+			server.Handler = router
+			go func() {
+				if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Logger.Error(err)
+				}
+			}()
+			return nil
+		},
+		OnStop: server.Shutdown,
+	})
 
 	log.Logger.Info("begin service")
-	select {}
+	if err := app.Run(); err != nil {
+		log.Logger.Error(err)
+	}
+	log.Logger.Info("service stopped")
 }
\ No newline at end of file
@@ -0,0 +1,90 @@
+package rabbitmq
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// RegisterAdminRoutes adds the rabbitmq admin endpoints to the router
+func RegisterAdminRoutes(router gin.IRouter) {
+	router.GET("/rabbitmq/quarantine/:queue", listQuarantinedHandler)
+	router.GET("/rabbitmq/quarantine/:queue/:id", getQuarantinedHandler)
+	router.DELETE("/rabbitmq/quarantine/:queue/:id", deleteQuarantinedHandler)
+	router.POST("/rabbitmq/quarantine/:queue/:id/replay", replayQuarantinedHandler)
+	router.GET("/rabbitmq/consumers", listConsumersHandler)
+	router.GET("/rabbitmq/queues", queueStatsHandler)
+	router.POST("/rabbitmq/consumers/:tag/pause", pauseConsumerHandler)
+	router.POST("/rabbitmq/consumers/:tag/resume", resumeConsumerHandler)
+}
+
+func adminError(c *gin.Context, err error) {
+	code := -1
+	if err == ErrMessageNotFound || err == ErrConsumerNotFound {
+		code = -2
+	}
+	c.JSON(http.StatusOK, gin.H{ "code" : code, "message" : err.Error() })
+}
+
+func listQuarantinedHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusOK, gin.H{ "code" : -1, "message" : "invalid limit" })
+		return
+	}
+	messages, err := ListQuarantined(c.Param("queue"), limit)
+	if err != nil {
+		adminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{ "code" : 0, "data" : messages })
+}
+
+func getQuarantinedHandler(c *gin.Context) {
+	m, err := GetQuarantined(c.Param("queue"), c.Param("id"))
+	if err != nil {
+		adminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{ "code" : 0, "data" : m })
+}
+
+func deleteQuarantinedHandler(c *gin.Context) {
+	if err := DeleteQuarantined(c.Param("queue"), c.Param("id")); err != nil {
+		adminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{ "code" : 0 })
+}
+
+func replayQuarantinedHandler(c *gin.Context) {
+	if err := ReplayQuarantined(c.Param("queue"), c.Param("id")); err != nil {
+		adminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{ "code" : 0 })
+}
+
+func listConsumersHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{ "code" : 0, "data" : ListConsumers() })
+}
+
+func pauseConsumerHandler(c *gin.Context) {
+	if err := PauseConsumer(c.Param("tag")); err != nil {
+		adminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{ "code" : 0 })
+}
+
+func resumeConsumerHandler(c *gin.Context) {
+	if err := ResumeConsumer(c.Param("tag")); err != nil {
+		adminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{ "code" : 0 })
+}
+
+func queueStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{ "code" : 0, "data" : QueueStatsAll(), "metrics" : GetMetrics() })
+}
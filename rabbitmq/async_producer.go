@@ -0,0 +1,349 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNacked         = errors.New("message nacked by broker")
+	ErrConfirmTimeout = errors.New("wait for publisher confirm timeout")
+)
+
+// Message is the unit handed to an AsyncProducer
+type Message struct {
+	// Routing key of this message, empty means the routing key of the producer binding
+	RoutingKey string
+
+	// Content type of the body, default text/plain
+	ContentType string
+
+	Headers amqp.Table
+	Body    []byte
+
+	// Metadata is not sent to the broker, it is returned untouched on Successes and Errors
+	Metadata interface{}
+}
+
+func (m *Message) publishing() amqp.Publishing {
+	contentType := m.ContentType
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	return amqp.Publishing{
+		ContentType: contentType,
+		Headers:     m.Headers,
+		Body:        m.Body,
+	}
+}
+
+// ProducerError is sent on the Errors channel of an AsyncProducer
+type ProducerError struct {
+	Msg Message
+	Err error
+}
+
+func (pe *ProducerError) Error() string {
+	return fmt.Sprintf("rabbitmq: failed to publish message: %s", pe.Err)
+}
+
+// ProducerErrors is returned by AsyncProducer.Close
+type ProducerErrors []*ProducerError
+
+func (pe ProducerErrors) Error() string {
+	return fmt.Sprintf("rabbitmq: failed to publish %d messages", len(pe))
+}
+
+type AsyncProducerConfig struct {
+	// Number of background goroutines, each of them owns one pooled channel
+	Workers int
+
+	// A batch is published when it reaches BatchSize messages or FlushInterval elapsed
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// How long to wait for the publisher confirms of a batch
+	ConfirmTimeout time.Duration
+
+	// Buffer size of the Input, Successes and Errors channels
+	ChannelBufferSize int
+
+	// If true, every acked message is sent on Successes and Successes must be read
+	ReturnSuccesses bool
+
+	// Retry policy for failed messages, nil disables retry
+	Retry *RetryPolicy
+}
+
+func DefaultAsyncProducerConfig() AsyncProducerConfig {
+	return AsyncProducerConfig{
+		Workers:           2,
+		BatchSize:         100,
+		FlushInterval:     100 * time.Millisecond,
+		ConfirmTimeout:    10 * time.Second,
+		ChannelBufferSize: 256,
+		ReturnSuccesses:   false,
+		Retry:             DefaultRetryPolicy(),
+	}
+}
+
+//thread-safe
+//Input/Successes/Errors的用法和sarama的AsyncProducer一致, Errors必须被读取, 否则worker会被阻塞
+type AsyncProducer struct {
+	session   Session
+	config    AsyncProducerConfig
+	input     chan Message
+	successes chan Message
+	errors    chan *ProducerError
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func NewAsyncProducer(e Exchange, bo BindingOptions, config AsyncProducerConfig) *AsyncProducer {
+	def := DefaultAsyncProducerConfig()
+	if config.Workers <= 0 { config.Workers = def.Workers }
+	if config.BatchSize <= 0 { config.BatchSize = def.BatchSize }
+	if config.FlushInterval <= 0 { config.FlushInterval = def.FlushInterval }
+	if config.ConfirmTimeout <= 0 { config.ConfirmTimeout = def.ConfirmTimeout }
+	if config.ChannelBufferSize < 0 { config.ChannelBufferSize = def.ChannelBufferSize }
+
+	ap := &AsyncProducer{
+		session: Session{
+			Exchange:       e,
+			BindingOptions: bo,
+		},
+		config:    config,
+		input:     make(chan Message, config.ChannelBufferSize),
+		successes: make(chan Message, config.ChannelBufferSize),
+		errors:    make(chan *ProducerError, config.ChannelBufferSize),
+	}
+	unique := strconv.FormatInt(time.Now().UnixNano(), 10)
+	for i := 0; i < config.Workers; i++ {
+		w := &asyncWorker{
+			ap:       ap,
+			producer: NewSafeProducer(e, bo, "async"+unique+"-"+strconv.Itoa(i)),
+		}
+		ap.wg.Add(1)
+		go w.run()
+	}
+	return ap
+}
+
+func (ap *AsyncProducer) Input() chan<- Message {
+	return ap.input
+}
+
+func (ap *AsyncProducer) Successes() <-chan Message {
+	return ap.successes
+}
+
+func (ap *AsyncProducer) Errors() <-chan *ProducerError {
+	return ap.errors
+}
+
+// AsyncClose stops accepting messages, the output channels are closed after
+// every buffered message has been flushed
+func (ap *AsyncProducer) AsyncClose() {
+	ap.closeOnce.Do(func() {
+		close(ap.input)
+		go func() {
+			ap.wg.Wait()
+			close(ap.successes)
+			close(ap.errors)
+		}()
+	})
+}
+
+// Close flushes every buffered message and blocks until all workers exit.
+// The remaining errors are drained and returned.
+func (ap *AsyncProducer) Close() error {
+	ap.AsyncClose()
+	if ap.config.ReturnSuccesses {
+		go func() {
+			for range ap.successes {
+			}
+		}()
+	}
+	var errs ProducerErrors
+	for pe := range ap.errors {
+		errs = append(errs, pe)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (ap *AsyncProducer) succeed(msg Message) {
+	countPublish(nil)
+	if ap.config.ReturnSuccesses {
+		ap.successes <- msg
+	}
+}
+
+func (ap *AsyncProducer) fail(pes []*ProducerError) {
+	for _, pe := range pes {
+		countPublish(pe.Err)
+		ap.errors <- pe
+	}
+}
+
+func failed(msgs []Message, err error) []*ProducerError {
+	pes := make([]*ProducerError, 0, len(msgs))
+	for _, msg := range msgs {
+		pes = append(pes, &ProducerError{Msg: msg, Err: err})
+	}
+	return pes
+}
+
+type asyncWorker struct {
+	ap       *AsyncProducer
+	producer *Producer
+	conn     *Connection
+	channel  *Channel
+	confirms chan amqp.Confirmation
+}
+
+func (w *asyncWorker) run() {
+	defer w.ap.wg.Done()
+	defer w.release()
+
+	batch := make([]Message, 0, w.ap.config.BatchSize)
+	ticker := time.NewTicker(w.ap.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-w.ap.input:
+			if !ok {
+				w.send(batch)
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) >= w.ap.config.BatchSize {
+				w.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.send(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+//worker独占这个channel, confirm模式的channel不能放回idle pool
+func (w *asyncWorker) ensureChannel() error {
+	if w.channel != nil {
+		return nil
+	}
+	conn, ch, err := w.producer.acquire()
+	if err != nil { return err }
+	if err := conn.occupied(ch, true, true); err != nil {
+		ch.close(MQTypeProducer, nil)
+		return err
+	}
+	if err := ch.channel.Confirm(false); err != nil {
+		conn.discard(ch.tag, MQTypeProducer)
+		return err
+	}
+	w.confirms = ch.channel.NotifyPublish(make(chan amqp.Confirmation, w.ap.config.BatchSize))
+	w.conn = conn
+	w.channel = ch
+	return nil
+}
+
+func (w *asyncWorker) release() {
+	if w.channel == nil {
+		return
+	}
+	if err := w.conn.discard(w.channel.tag, MQTypeProducer); err != nil {
+		log.Logger.Error("async producer release channel error: ", err.Error())
+	}
+	w.conn = nil
+	w.channel = nil
+	w.confirms = nil
+}
+
+//失败且可以重试的消息会在新的channel上重新发送
+func (w *asyncWorker) send(batch []Message) {
+	pending := make([]Message, 0, len(batch))
+	for _, msg := range batch {
+		if err := pool.allowPublish(w.producer.session.Exchange.Name); err != nil {
+			w.ap.fail([]*ProducerError{{Msg: msg, Err: err}})
+			continue
+		}
+		pending = append(pending, msg)
+	}
+	for attempt := 1; len(pending) > 0; attempt++ {
+		var retry []Message
+		var lastErr error
+		for _, pe := range w.flush(pending) {
+			if w.ap.config.Retry.shouldRetry(attempt, pe.Err) {
+				retry = append(retry, pe.Msg)
+				lastErr = pe.Err
+			} else {
+				w.ap.fail([]*ProducerError{pe})
+			}
+		}
+		if len(retry) > 0 {
+			log.Logger.Info("async producer retry ", len(retry), " messages, attempt ", attempt, ": ", lastErr.Error())
+			w.release()
+			time.Sleep(w.ap.config.Retry.backoff(attempt))
+		}
+		pending = retry
+	}
+}
+
+func (w *asyncWorker) flush(batch []Message) []*ProducerError {
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := w.ensureChannel(); err != nil {
+		return failed(batch, err)
+	}
+
+	var pes []*ProducerError
+	published := 0
+	for _, msg := range batch {
+		routingKey := msg.RoutingKey
+		if routingKey == "" {
+			routingKey = w.producer.session.BindingOptions.RoutingKey
+		}
+		if err := w.channel.publish(w.producer.session.Exchange, routingKey, msg.publishing()); err != nil {
+			pes = append(pes, failed(batch[published:], err)...)
+			break
+		}
+		published++
+	}
+
+	//confirm按delivery tag顺序返回, 和batch中的顺序一致
+	timeout := time.NewTimer(w.ap.config.ConfirmTimeout)
+	defer timeout.Stop()
+	for i := 0; i < published; i++ {
+		select {
+		case confirm, ok := <-w.confirms:
+			if !ok {
+				w.release()
+				return append(pes, failed(batch[i:published], amqp.ErrClosed)...)
+			}
+			if confirm.Ack {
+				w.ap.succeed(batch[i])
+			} else {
+				pes = append(pes, &ProducerError{Msg: batch[i], Err: ErrNacked})
+			}
+		case <-timeout.C:
+			w.release()
+			return append(pes, failed(batch[i:published], ErrConfirmTimeout)...)
+		}
+	}
+	if published < len(batch) {
+		w.release()
+	}
+	return pes
+}
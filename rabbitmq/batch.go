@@ -0,0 +1,174 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// BatchHandler handles up to size deliveries at once. A nil error acks the
+// whole batch, a *BatchError settles the deliveries one by one, any other
+// error is applied to every delivery like the error of a HandlerFunc.
+type BatchHandler func(ctx context.Context, deliveries []amqp.Delivery) error
+
+// BatchError reports the deliveries of a batch that failed, the key is the
+// index in the batch and the others are acked
+type BatchError struct {
+	Errors map[int]error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d deliveries of the batch failed", len(e.Errors))
+}
+
+type batcher struct {
+	mu       sync.Mutex
+	consumer *Consumer
+	size     int
+	wait     time.Duration
+	autoAck  bool
+	handler  BatchHandler
+	pending  []amqp.Delivery
+	timer    *time.Timer
+}
+
+// ConsumeBatch gathers up to size deliveries, or what arrived within wait after
+// the first one, and calls handler once. Batches are handled one at a time, so
+// the consumer is changed for good: QOS is raised to at least size and the
+// settings of Concurrency and KeyOrdered are cleared.
+func (c *Consumer) ConsumeBatch(size int, wait time.Duration, handler BatchHandler) error {
+	if size <= 0 {
+		return fmt.Errorf("batch size must be positive")
+	}
+	//没有等待时间时不足size的batch永远不会被处理
+	if wait <= 0 {
+		return fmt.Errorf("batch wait must be positive")
+	}
+	if c.QOS < size {
+		c.QOS = size
+	}
+	//multiple ack要求消息按delivery tag顺序进入batch
+	c.concurrency = 0
+	c.keyFunc = nil
+	b := &batcher{
+		consumer: c,
+		size:     size,
+		wait:     wait,
+		autoAck:  c.session.ConsumerOptions.AutoAck,
+		handler:  handler,
+	}
+	c.drained = b.drain
+	defer func() { c.drained = nil }()
+	return c.Consume(b.add)
+}
+
+func (b *batcher) add(delivery amqp.Delivery) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, delivery)
+	if len(b.pending) >= b.size {
+		b.flush()
+		return
+	}
+	if len(b.pending) == 1 && b.wait > 0 {
+		b.timer = time.AfterFunc(b.wait, b.expire)
+	}
+}
+
+//deliveries关闭后调用, Pause时channel仍然可用, 处理剩下的batch
+//否则channel已经失效, 未确认的消息会被broker重新投递, 直接丢弃
+func (b *batcher) drain(paused bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if paused {
+		b.flush()
+		return
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) > 0 {
+		log.Logger.Info("deliveries closed, drop ", len(b.pending), " pending deliveries of the batch")
+		b.pending = nil
+	}
+}
+
+func (b *batcher) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flush()
+}
+
+//no-lock 持有锁调用handler, 保证同一时间只有一个batch未确认
+func (b *batcher) flush() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	deliveries := b.pending
+	b.pending = nil
+
+	err := callBatchHandler(context.Background(), b.handler, deliveries)
+	if err != nil {
+		log.Logger.Error("handle batch of ", len(deliveries), " deliveries error: ", err.Error())
+	}
+	if b.autoAck {
+		return
+	}
+	b.settle(deliveries, err)
+}
+
+func (b *batcher) settle(deliveries []amqp.Delivery, err error) {
+	if be, ok := err.(*BatchError); ok {
+		for i, d := range deliveries {
+			b.consumer.settle(d, be.Errors[i])
+		}
+		return
+	}
+	//分片合并的消息有自己的Acknowledger, 不能使用multiple
+	//开启合并或者隔离时channel上还有不在batch中的未确认消息(例如未收齐的分片), multiple会把它们一起ack
+	last := deliveries[len(deliveries)-1]
+	multiple := b.consumer.reassembly == nil && b.consumer.quarantine == nil
+	for _, d := range deliveries {
+		if d.Acknowledger != last.Acknowledger {
+			multiple = false
+			break
+		}
+	}
+	if !multiple || (err != nil && b.consumer.retryTopology != nil) {
+		for _, d := range deliveries {
+			b.consumer.settle(d, err)
+		}
+		return
+	}
+
+	var err1 error
+	switch {
+	case err == nil:
+		err1 = last.Ack(true)
+	case IsRequeue(err):
+		err1 = last.Nack(true, true)
+	default:
+		err1 = last.Nack(true, false)
+	}
+	if err1 != nil {
+		log.Logger.Error(err1.Error())
+	}
+}
+
+func callBatchHandler(ctx context.Context, handler BatchHandler, deliveries []amqp.Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return handler(ctx, deliveries)
+}
@@ -0,0 +1,69 @@
+package rabbitmq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatchSettle(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name       string
+		reassembly bool
+		quarantine bool
+		err        error
+		want       []settlement
+	}{
+		{
+			name: "ack with multiple",
+			want: []settlement{{tag: 3, ack: true, multiple: true}},
+		},
+		{
+			name: "requeue with multiple",
+			err:  Requeue(errFailed),
+			want: []settlement{{tag: 3, requeue: true, multiple: true}},
+		},
+		{
+			name:       "reassembly acks one by one",
+			reassembly: true,
+			want:       []settlement{{tag: 1, ack: true}, {tag: 2, ack: true}, {tag: 3, ack: true}},
+		},
+		{
+			name:       "quarantine nacks one by one",
+			quarantine: true,
+			err:        errFailed,
+			want:       []settlement{{tag: 1}, {tag: 2}, {tag: 3}},
+		},
+		{
+			name: "batch error",
+			err:  &BatchError{Errors: map[int]error{1: Requeue(errFailed)}},
+			want: []settlement{{tag: 1, ack: true}, {tag: 2, requeue: true}, {tag: 3, ack: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConsumer(Exchange{}, Queue{Name: "batch"}, BindingOptions{}, ConsumerOptions{}, "batch")
+			if tt.reassembly {
+				if err := c.SetReassembly(time.Minute, 0, 4); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.quarantine {
+				c.quarantine = &quarantine{threshold: 3}
+			}
+			ack := &recordAcknowledger{}
+			deliveries := make([]amqp.Delivery, 3)
+			for i := range deliveries {
+				deliveries[i] = amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1)}
+			}
+			b := &batcher{consumer: c}
+			b.settle(deliveries, tt.err)
+			if got := ack.settlements(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("settled %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
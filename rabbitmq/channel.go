@@ -15,11 +15,27 @@ type Channel struct {
 	handler func(amqp.Delivery)
 
 	connTag int
+
+	// Shared with the connection, see topologyCache
+	topology *topologyCache
+
+	// Number of handler goroutines and the optional ordering key, see Consumer.Concurrency
+	workers int
+	keyFunc KeyFunc
+
+	// Set by Consumer, see Consumer.Pause
+	pause *pauseControl
+	// Called after the deliveries are closed and handled, see Consumer.ConsumeBatch
+	drained func(paused bool)
 }
 
 func (c *Channel) clean(mqType MQType, co *ConsumerOptions) {
 	if (mqType == MQTypeConsumer) {
 		c.handler = nil
+		c.workers = 0
+		c.keyFunc = nil
+		c.pause = nil
+		c.drained = nil
 		if co != nil {
 			// This waits for a server acknowledgment which means the sockets will have
 			// flushed all outbound publishings prior to returning.  It's important to
@@ -39,15 +55,12 @@ func (c *Channel) close(mqType MQType, co *ConsumerOptions) {
 	}
 }
 
-func (ch *Channel) publish(e Exchange, b BindingOptions, body []byte) error {
+func (ch *Channel) publish(e Exchange, routingKey string, msg amqp.Publishing) error {
 	//mandatory: 当mandatory标志位设置为true时，如果exchange根据自身类型和消息routeKey无法找到一个符合条件的queue，那么会将消息返回给生产者
 	//当mandatory设置为false时，出现上述情形broker会直接将消息扔掉
 	//immediate: 当immediate标志位设置为true时，如果exchange在将消息路由到queue(s)时发现对于的queue上么有消费者，那么这条消息不会放入队列中。
 	//当与消息routeKey关联的所有queue（一个或者多个）都没有消费者时，该消息会通过basic.return方法返还给生产者。
-	err := ch.channel.Publish(e.Name, b.RoutingKey, false, false, amqp.Publishing{
-		ContentType: "text/plain",
-		Body: body,
-	})
+	err := ch.channel.Publish(e.Name, routingKey, false, false, msg)
 	return err
 }
 
@@ -56,34 +69,41 @@ func (ch *Channel) consumer(co ConsumerOptions, b BindingOptions, q Queue, qos i
 	//global: 全局channel还是该channel
 	//只有在autoAck为false时有效
 	//同时每个consumer都会预取n个消息 注意多进程多消费者消费同一个数据的问题
-	err := ch.channel.Qos(qos, 0, false)
-	if err != nil {
-		return err
-	}
-	// Exchange bound to Queue, starting Consume
-	deliveries, err := ch.channel.Consume(
-		// consume from real queue
-		q.Name,       // name
-		co.Tag,       // consumerTag,
-		co.AutoAck,   // autoAck
-		co.Exclusive, // exclusive
-		co.NoLocal,   // noLocal
-		co.NoWait,    // noWait
-		co.Args,      // arguments
-	)
-	if err != nil {
-		return err
-	}
-	ch.deliveries = deliveries
-	ch.handler = handler
+	for {
+		err := ch.channel.Qos(qos, 0, false)
+		if err != nil {
+			return err
+		}
+		// Exchange bound to Queue, starting Consume
+		deliveries, err := ch.channel.Consume(
+			// consume from real queue
+			q.Name,       // name
+			co.Tag,       // consumerTag,
+			co.AutoAck,   // autoAck
+			co.Exclusive, // exclusive
+			co.NoLocal,   // noLocal
+			co.NoWait,    // noWait
+			co.Args,      // arguments
+		)
+		if err != nil {
+			return err
+		}
+		ch.deliveries = deliveries
+		ch.handler = handler
 
-	log.Logger.Info("handle:", b.RoutingKey, " deliveries channel starting, connection tag ", ch.connTag)
-	// handle all consumer errors, if required re-connect
-	// there are problems with reconnection logic for now
-	for delivery := range ch.deliveries {
-		handler(delivery)
+		log.Logger.Info("handle:", b.RoutingKey, " deliveries channel starting, connection tag ", ch.connTag)
+		// handle all consumer errors, if required re-connect
+		// there are problems with reconnection logic for now
+		ch.dispatch(co.AutoAck, handler)
+		log.Logger.Info("handle:", b.RoutingKey, " deliveries channel closed, connection tag ", ch.connTag)
+		if ch.drained != nil {
+			ch.drained(ch.pause.isPaused())
+		}
+
+		//被Pause取消时等待Resume后在同一个channel上重新consume
+		if !ch.pause.wait() {
+			return nil
+		}
 	}
-	log.Logger.Info("handle:", b.RoutingKey, " deliveries channel closed, connection tag ", ch.connTag)
-	return nil
 }
 
@@ -0,0 +1,301 @@
+package rabbitmq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderChunkID    = "x-chunk-id"
+	HeaderChunkIndex = "x-chunk-index"
+	HeaderChunkTotal = "x-chunk-total"
+)
+
+func newMessageID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+//header中的整数可能被解码为不同宽度的类型
+func headerInt(headers amqp.Table, key string) (int64, bool) {
+	switch v := headers[key].(type) {
+	case int8:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func copyTable(t amqp.Table) amqp.Table {
+	n := make(amqp.Table, len(t)+3)
+	for k, v := range t {
+		n[k] = v
+	}
+	return n
+}
+
+//把body切分成多条消息, 每条消息的header中带有message id, 序号和总数
+func splitPublishing(msg amqp.Publishing, size int) []amqp.Publishing {
+	id := msg.MessageId
+	if id == "" {
+		id = newMessageID()
+	}
+	total := (len(msg.Body) + size - 1) / size
+	chunks := make([]amqp.Publishing, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * size
+		if end > len(msg.Body) {
+			end = len(msg.Body)
+		}
+		chunk := msg
+		chunk.MessageId = id
+		chunk.Headers = copyTable(msg.Headers)
+		chunk.Headers[HeaderChunkID] = id
+		chunk.Headers[HeaderChunkIndex] = int32(i)
+		chunk.Headers[HeaderChunkTotal] = int32(total)
+		chunk.Body = msg.Body[i*size : end]
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func chunkInfo(delivery amqp.Delivery) (id string, index int, total int, ok bool) {
+	id, ok = delivery.Headers[HeaderChunkID].(string)
+	if !ok {
+		return "", 0, 0, false
+	}
+	idx, ok1 := headerInt(delivery.Headers, HeaderChunkIndex)
+	tot, ok2 := headerInt(delivery.Headers, HeaderChunkTotal)
+	if !ok1 || !ok2 || tot <= 0 || idx < 0 || idx >= tot {
+		return "", 0, 0, false
+	}
+	return id, int(idx), int(tot), true
+}
+
+//ack/nack合并后的消息时, 对每一个分片分别ack/nack
+type chunkAcknowledger struct {
+	deliveries []amqp.Delivery
+}
+
+func (ca *chunkAcknowledger) Ack(tag uint64, multiple bool) error {
+	for _, d := range ca.deliveries {
+		if err := d.Ack(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ca *chunkAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	for _, d := range ca.deliveries {
+		if err := d.Nack(false, requeue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ca *chunkAcknowledger) Reject(tag uint64, requeue bool) error {
+	for _, d := range ca.deliveries {
+		if err := d.Reject(requeue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type partialMessage struct {
+	id         string
+	deliveries []amqp.Delivery
+	received   int
+	size       int
+	timer      *time.Timer
+}
+
+//缓存分片直到收齐, 超时或者超过内存上限的消息会被重新入队
+//分片数超过maxChunks的消息在qos限制下永远无法收齐, 直接nack
+type reassembler struct {
+	mu        sync.Mutex
+	timeout   time.Duration
+	maxBytes  int
+	maxChunks int
+	size      int
+	autoAck   bool
+	partials map[string]*partialMessage
+	order    []string
+}
+
+func newReassembler(timeout time.Duration, maxBytes int, maxChunks int, autoAck bool) *reassembler {
+	return &reassembler{
+		timeout:   timeout,
+		maxBytes:  maxBytes,
+		maxChunks: maxChunks,
+		autoAck:   autoAck,
+		partials:  make(map[string]*partialMessage),
+	}
+}
+
+func (r *reassembler) wrap(handler func(delivery amqp.Delivery)) func(delivery amqp.Delivery) {
+	return func(delivery amqp.Delivery) {
+		id, index, total, ok := chunkInfo(delivery)
+		if !ok {
+			handler(delivery)
+			return
+		}
+		if whole, ok := r.add(delivery, id, index, total); ok {
+			handler(whole)
+		}
+	}
+}
+
+func (r *reassembler) add(delivery amqp.Delivery, id string, index, total int) (amqp.Delivery, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxChunks > 0 && total > r.maxChunks {
+		log.Logger.Error("message ", id, " has ", total, " chunks, more than the reassembly limit ", r.maxChunks)
+		r.drop(id, false)
+		r.nack(delivery, false)
+		return amqp.Delivery{}, false
+	}
+	if r.maxBytes > 0 && len(delivery.Body) > r.maxBytes {
+		log.Logger.Error("chunk of message ", id, " is larger than reassembly memory cap")
+		r.drop(id, false)
+		r.nack(delivery, false)
+		return amqp.Delivery{}, false
+	}
+	//超过内存上限时把最早的未完成消息重新入队
+	for r.maxBytes > 0 && r.size+len(delivery.Body) > r.maxBytes && len(r.order) > 0 {
+		log.Logger.Error("reassembly memory cap exceeded, requeue message ", r.order[0])
+		r.drop(r.order[0], true)
+	}
+
+	p := r.partials[id]
+	if p == nil || len(p.deliveries) != total {
+		r.drop(id, false)
+		p = &partialMessage{
+			id:         id,
+			deliveries: make([]amqp.Delivery, total),
+		}
+		if r.timeout > 0 {
+			p.timer = time.AfterFunc(r.timeout, func() {
+				r.expire(p)
+			})
+		}
+		r.partials[id] = p
+		r.order = append(r.order, id)
+	}
+	if old := p.deliveries[index]; old.Acknowledger != nil {
+		//重复投递的分片, 之前的delivery已经失效
+		p.size -= len(old.Body)
+		r.size -= len(old.Body)
+	} else {
+		p.received++
+	}
+	p.deliveries[index] = delivery
+	p.size += len(delivery.Body)
+	r.size += len(delivery.Body)
+
+	if p.received < total {
+		return amqp.Delivery{}, false
+	}
+	r.remove(id)
+	whole, err := p.assemble()
+	if err != nil {
+		log.Logger.Error("decompress message ", id, " error: ", err.Error())
+		for _, d := range p.deliveries {
+			r.nack(d, false)
+		}
+		return amqp.Delivery{}, false
+	}
+	return whole, true
+}
+
+//压缩发生在拆分之前, 所以收齐后再解压
+func (p *partialMessage) assemble() (amqp.Delivery, error) {
+	body := make([]byte, 0, p.size)
+	for _, d := range p.deliveries {
+		body = append(body, d.Body...)
+	}
+	whole := p.deliveries[0]
+	whole.Body = body
+	whole.Headers = copyTable(whole.Headers)
+	delete(whole.Headers, HeaderChunkID)
+	delete(whole.Headers, HeaderChunkIndex)
+	delete(whole.Headers, HeaderChunkTotal)
+	whole.DeliveryTag = p.deliveries[len(p.deliveries)-1].DeliveryTag
+	whole.Acknowledger = &chunkAcknowledger{deliveries: p.deliveries}
+	if err := decompressDelivery(&whole); err != nil {
+		return amqp.Delivery{}, err
+	}
+	return whole, nil
+}
+
+func (r *reassembler) expire(p *partialMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.partials[p.id] != p {
+		return
+	}
+	log.Logger.Error("reassembly of message ", p.id, " timeout, ", p.received, "/", len(p.deliveries), " chunks received, requeue")
+	r.drop(p.id, true)
+}
+
+//no-lock 丢弃未完成的消息并nack已收到的分片
+func (r *reassembler) drop(id string, requeue bool) {
+	p := r.partials[id]
+	if p == nil {
+		return
+	}
+	r.remove(id)
+	for _, d := range p.deliveries {
+		if d.Acknowledger != nil {
+			r.nack(d, requeue)
+		}
+	}
+}
+
+//no-lock
+func (r *reassembler) remove(id string) {
+	p := r.partials[id]
+	if p == nil {
+		return
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	r.size -= p.size
+	delete(r.partials, id)
+	for i, o := range r.order {
+		if o == id {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (r *reassembler) nack(delivery amqp.Delivery, requeue bool) {
+	if r.autoAck {
+		return
+	}
+	if err := delivery.Nack(false, requeue); err != nil {
+		log.Logger.Error(err.Error())
+	}
+}
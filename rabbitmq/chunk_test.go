@@ -0,0 +1,259 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"github.com/streadway/amqp"
+	"sync"
+	"testing"
+	"time"
+)
+
+type settlement struct {
+	tag      uint64
+	ack      bool
+	requeue  bool
+	multiple bool
+}
+
+//记录ack/nack的Acknowledger
+type recordAcknowledger struct {
+	mu      sync.Mutex
+	settled []settlement
+}
+
+func (r *recordAcknowledger) Ack(tag uint64, multiple bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settled = append(r.settled, settlement{tag: tag, ack: true, multiple: multiple})
+	return nil
+}
+
+func (r *recordAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settled = append(r.settled, settlement{tag: tag, requeue: requeue, multiple: multiple})
+	return nil
+}
+
+func (r *recordAcknowledger) Reject(tag uint64, requeue bool) error {
+	return r.Nack(tag, false, requeue)
+}
+
+func (r *recordAcknowledger) settlements() []settlement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]settlement(nil), r.settled...)
+}
+
+//把发布的分片转换成consumer收到的delivery
+func chunkDeliveries(ack amqp.Acknowledger, chunks []amqp.Publishing) []amqp.Delivery {
+	deliveries := make([]amqp.Delivery, len(chunks))
+	for i, c := range chunks {
+		deliveries[i] = amqp.Delivery{
+			Acknowledger:    ack,
+			DeliveryTag:     uint64(i + 1),
+			Headers:         c.Headers,
+			ContentType:     c.ContentType,
+			ContentEncoding: c.ContentEncoding,
+			MessageId:       c.MessageId,
+			Body:            c.Body,
+		}
+	}
+	return deliveries
+}
+
+func TestSplitPublishing(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		size   int
+		chunks []string
+	}{
+		{name: "exact", body: "abcdef", size: 3, chunks: []string{"abc", "def"}},
+		{name: "remainder", body: "abcdefg", size: 3, chunks: []string{"abc", "def", "g"}},
+		{name: "single", body: "abc", size: 10, chunks: []string{"abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := amqp.Publishing{Headers: amqp.Table{"k": "v"}, Body: []byte(tt.body)}
+			chunks := splitPublishing(msg, tt.size)
+			if len(chunks) != len(tt.chunks) {
+				t.Fatalf("got %d chunks, want %d", len(chunks), len(tt.chunks))
+			}
+			for i, d := range chunkDeliveries(nil, chunks) {
+				id, index, total, ok := chunkInfo(d)
+				if !ok || id != chunks[0].MessageId || index != i || total != len(tt.chunks) {
+					t.Errorf("chunk %d info = %s %d %d %v", i, id, index, total, ok)
+				}
+				if string(d.Body) != tt.chunks[i] {
+					t.Errorf("chunk %d body = %q, want %q", i, d.Body, tt.chunks[i])
+				}
+				if d.Headers["k"] != "v" {
+					t.Errorf("chunk %d lost header k", i)
+				}
+			}
+			if _, ok := msg.Headers[HeaderChunkID]; ok {
+				t.Errorf("headers of the original message are modified")
+			}
+		})
+	}
+}
+
+func TestReassemble(t *testing.T) {
+	body := bytes.Repeat([]byte("rabbitmq chunk "), 100)
+	tests := []struct {
+		name     string
+		compress Compressor
+		order    []int
+	}{
+		{name: "in order", order: []int{0, 1, 2, 3}},
+		{name: "out of order", order: []int{2, 0, 3, 1}},
+		{name: "gzip", compress: gzipCompressor{}, order: []int{0, 1, 2, 3}},
+		{name: "deflate out of order", compress: deflateCompressor{}, order: []int{3, 2, 1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := compressPublishing(tt.compress, 0, amqp.Publishing{Body: body})
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := (len(msg.Body) + len(tt.order) - 1) / len(tt.order)
+			ack := &recordAcknowledger{}
+			deliveries := chunkDeliveries(ack, splitPublishing(msg, size))
+			if len(deliveries) != len(tt.order) {
+				t.Fatalf("got %d chunks, want %d", len(deliveries), len(tt.order))
+			}
+
+			//与Consume相同, 分片经过dispatch交给reassembler
+			in := make(chan amqp.Delivery, len(deliveries))
+			for _, i := range tt.order {
+				in <- deliveries[i]
+			}
+			close(in)
+			var got []amqp.Delivery
+			r := newReassembler(time.Minute, 0, len(deliveries), false)
+			ch := &Channel{deliveries: in}
+			ch.dispatch(false, r.wrap(func(delivery amqp.Delivery) {
+				got = append(got, delivery)
+			}))
+
+			if len(got) != 1 {
+				t.Fatalf("handler called %d times, want 1", len(got))
+			}
+			whole := got[0]
+			if !bytes.Equal(whole.Body, body) {
+				t.Errorf("body = %q, want %q", whole.Body, body)
+			}
+			if whole.ContentEncoding != "" {
+				t.Errorf("content encoding = %s, want decompressed", whole.ContentEncoding)
+			}
+			if _, ok := whole.Headers[HeaderChunkID]; ok {
+				t.Errorf("chunk headers are not removed")
+			}
+			if err := whole.Ack(false); err != nil {
+				t.Fatal(err)
+			}
+			settled := ack.settlements()
+			if len(settled) != len(deliveries) {
+				t.Fatalf("%d chunks settled, want %d", len(settled), len(deliveries))
+			}
+			for _, s := range settled {
+				if !s.ack {
+					t.Errorf("chunk %d is not acked", s.tag)
+				}
+			}
+		})
+	}
+}
+
+func TestReassemblerRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      []byte
+		size      int
+		maxBytes  int
+		maxChunks int
+		encoding  string
+		// chunks nacked without requeue
+		nacked int
+	}{
+		{name: "too many chunks", body: []byte("abcdef"), size: 2, maxChunks: 2, nacked: 3},
+		{name: "chunk larger than memory cap", body: []byte("abcdef"), size: 3, maxBytes: 2, maxChunks: 2, nacked: 2},
+		{name: "undecodable body", body: []byte("not gzip"), size: 4, maxChunks: 2, encoding: "gzip", nacked: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &recordAcknowledger{}
+			msg := amqp.Publishing{ContentEncoding: tt.encoding, Body: tt.body}
+			r := newReassembler(time.Minute, tt.maxBytes, tt.maxChunks, false)
+			handler := r.wrap(func(delivery amqp.Delivery) {
+				t.Errorf("handler called with %q", delivery.Body)
+			})
+			for _, d := range chunkDeliveries(ack, splitPublishing(msg, tt.size)) {
+				handler(d)
+			}
+			settled := ack.settlements()
+			if len(settled) != tt.nacked {
+				t.Fatalf("%d chunks settled, want %d", len(settled), tt.nacked)
+			}
+			for _, s := range settled {
+				if s.ack || s.requeue {
+					t.Errorf("chunk %d settled %+v, want nack without requeue", s.tag, s)
+				}
+			}
+			if len(r.partials) != 0 || r.size != 0 {
+				t.Errorf("reassembler keeps %d partial messages, %d bytes", len(r.partials), r.size)
+			}
+		})
+	}
+}
+
+func TestReassemblerRequeues(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		ack := &recordAcknowledger{}
+		r := newReassembler(10*time.Millisecond, 0, 4, false)
+		deliveries := chunkDeliveries(ack, splitPublishing(amqp.Publishing{Body: []byte("abcdef")}, 2))
+		handler := r.wrap(func(delivery amqp.Delivery) {
+			t.Errorf("handler called with %q", delivery.Body)
+		})
+		handler(deliveries[0])
+		handler(deliveries[1])
+
+		deadline := time.Now().Add(time.Second)
+		for len(ack.settlements()) < 2 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		settled := ack.settlements()
+		if len(settled) != 2 {
+			t.Fatalf("%d chunks settled, want 2", len(settled))
+		}
+		for _, s := range settled {
+			if s.ack || !s.requeue {
+				t.Errorf("chunk %d settled %+v, want requeue", s.tag, s)
+			}
+		}
+	})
+
+	t.Run("memory cap", func(t *testing.T) {
+		ack := &recordAcknowledger{}
+		r := newReassembler(time.Minute, 5, 4, false)
+		first := chunkDeliveries(ack, splitPublishing(amqp.Publishing{MessageId: "first", Body: []byte("abcd")}, 2))
+		second := chunkDeliveries(ack, splitPublishing(amqp.Publishing{MessageId: "second", Body: []byte("efgh")}, 2))
+		var got []string
+		handler := r.wrap(func(delivery amqp.Delivery) {
+			got = append(got, string(delivery.Body))
+		})
+		handler(first[0])
+		handler(second[0])
+		//超过内存上限, first被重新入队
+		handler(second[1])
+
+		if len(got) != 1 || got[0] != "efgh" {
+			t.Errorf("handled %v, want [efgh]", got)
+		}
+		settled := ack.settlements()
+		if len(settled) != 1 || settled[0].ack || !settled[0].requeue {
+			t.Errorf("settled %+v, want first chunk requeued", settled)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"strings"
+	"sync"
+)
+
+// Codec encodes values into message bodies and decodes them back.
+// The ContentType is written into the content-type property of the publishing.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+var (
+	codecs = map[string]Codec{
+		JSONCodec.ContentType():     JSONCodec,
+		ProtobufCodec.ContentType(): ProtobufCodec,
+	}
+	codecMu sync.RWMutex
+)
+
+// RegisterCodec makes a codec available to consumers for its content type
+func RegisterCodec(c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+// CodecByContentType looks up a registered codec, parameters such as charset are ignored
+func CodecByContentType(contentType string) (Codec, bool) {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	c, ok := codecs[strings.ToLower(strings.TrimSpace(contentType))]
+	return c, ok
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+//根据delivery的content type选择codec解码, 解码失败的消息nack且不重新入队
+func typedHandler(autoAck bool, fallback Codec, newValue func() interface{}, handler func(v interface{}, delivery amqp.Delivery)) func(delivery amqp.Delivery) {
+	return func(delivery amqp.Delivery) {
+		codec, ok := CodecByContentType(delivery.ContentType)
+		if !ok && isPlainContentType(delivery.ContentType) {
+			codec, ok = fallback, true
+			if codec == nil {
+				codec = JSONCodec
+			}
+		}
+		if !ok {
+			log.Logger.Error("no codec for content type ", delivery.ContentType, ", nack delivery ", delivery.DeliveryTag)
+			nackUndecodable(autoAck, delivery)
+			return
+		}
+		v := newValue()
+		if err := codec.Unmarshal(delivery.Body, v); err != nil {
+			log.Logger.Error("decode delivery ", delivery.DeliveryTag, " error: ", err.Error())
+			nackUndecodable(autoAck, delivery)
+			return
+		}
+		handler(v, delivery)
+	}
+}
+
+//Publish总是使用text/plain, 这类消息没有说明编码方式
+func isPlainContentType(contentType string) bool {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	return contentType == "" || contentType == "text/plain"
+}
+
+func nackUndecodable(autoAck bool, delivery amqp.Delivery) {
+	//autoAck模式下delivery已经被ack
+	if autoAck {
+		return
+	}
+	if err := delivery.Nack(false, false); err != nil {
+		log.Logger.Error(err.Error())
+	}
+}
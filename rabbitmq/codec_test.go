@@ -0,0 +1,68 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+//只认识"plain:"前缀的codec, 用来区分fallback和JSONCodec
+type prefixCodec struct{}
+
+func (prefixCodec) ContentType() string { return "application/x-prefix" }
+
+func (prefixCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte("plain:" + *v.(*string)), nil
+}
+
+func (prefixCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data[len("plain:"):])
+	return nil
+}
+
+func TestTypedHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		fallback    Codec
+		want        string
+		// nacked without requeue
+		wantNack bool
+	}{
+		{name: "json", contentType: "application/json", body: `"a"`, fallback: prefixCodec{}, want: "a"},
+		{name: "json with charset", contentType: "application/json; charset=utf-8", body: `"a"`, want: "a"},
+		{name: "empty uses fallback", contentType: "", body: "plain:b", fallback: prefixCodec{}, want: "b"},
+		{name: "text/plain uses fallback", contentType: "text/plain", body: "plain:c", fallback: prefixCodec{}, want: "c"},
+		{name: "text/plain with charset", contentType: "Text/Plain; charset=utf-8", body: "plain:d", fallback: prefixCodec{}, want: "d"},
+		{name: "text/plain defaults to json", contentType: "text/plain", body: `"e"`, want: "e"},
+		{name: "unknown content type", contentType: "text/csv", body: "a,b", fallback: prefixCodec{}, wantNack: true},
+		{name: "undecodable", contentType: "text/plain", body: "not json", wantNack: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &recordAcknowledger{}
+			var got []string
+			handler := typedHandler(false, tt.fallback, func() interface{} { return new(string) }, func(v interface{}, delivery amqp.Delivery) {
+				got = append(got, *v.(*string))
+			})
+			handler(amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, ContentType: tt.contentType, Body: []byte(tt.body)})
+
+			settled := ack.settlements()
+			if tt.wantNack {
+				if len(got) != 0 {
+					t.Errorf("handler called with %v", got)
+				}
+				if len(settled) != 1 || settled[0].ack || settled[0].requeue {
+					t.Errorf("settled %+v, want nack without requeue", settled)
+				}
+				return
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("handled %v, want [%s]", got, tt.want)
+			}
+			if len(settled) != 0 {
+				t.Errorf("settled %+v before the handler", settled)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Compressor compresses message bodies, Encoding is written into the
+// content-encoding property so consumers can pick the matching decompressor.
+type Compressor interface {
+	Encoding() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	GzipCompressor    Compressor = gzipCompressor{}
+	DeflateCompressor Compressor = deflateCompressor{}
+)
+
+var (
+	compressors = map[string]Compressor{
+		GzipCompressor.Encoding():    GzipCompressor,
+		DeflateCompressor.Encoding(): DeflateCompressor,
+	}
+	compressorMu sync.RWMutex
+)
+
+// RegisterCompressor makes a compressor available to consumers for its encoding
+func RegisterCompressor(c Compressor) {
+	compressorMu.Lock()
+	defer compressorMu.Unlock()
+	compressors[c.Encoding()] = c
+}
+
+func CompressorByEncoding(encoding string) (Compressor, bool) {
+	compressorMu.RLock()
+	defer compressorMu.RUnlock()
+	c, ok := compressors[strings.ToLower(strings.TrimSpace(encoding))]
+	return c, ok
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Encoding() string { return "gzip" }
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+type deflateCompressor struct{}
+
+func (deflateCompressor) Encoding() string { return "deflate" }
+
+func (deflateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (deflateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+//body小于threshold或者已经设置了content encoding时不压缩
+func compressPublishing(c Compressor, threshold int, msg amqp.Publishing) (amqp.Publishing, error) {
+	if c == nil || len(msg.Body) < threshold || msg.ContentEncoding != "" {
+		return msg, nil
+	}
+	body, err := c.Compress(msg.Body)
+	if err != nil {
+		return msg, err
+	}
+	msg.Body = body
+	msg.ContentEncoding = c.Encoding()
+	return msg, nil
+}
+
+//未注册的content encoding原样交给handler
+func decompressDelivery(delivery *amqp.Delivery) error {
+	if delivery.ContentEncoding == "" {
+		return nil
+	}
+	c, ok := CompressorByEncoding(delivery.ContentEncoding)
+	if !ok {
+		return nil
+	}
+	body, err := c.Decompress(delivery.Body)
+	if err != nil {
+		return err
+	}
+	delivery.Body = body
+	delivery.ContentEncoding = ""
+	return nil
+}
@@ -0,0 +1,84 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestCompressPublishing(t *testing.T) {
+	body := bytes.Repeat([]byte("rabbitmq compression "), 50)
+	tests := []struct {
+		name      string
+		compress  Compressor
+		threshold int
+		encoding  string
+		// content encoding after compressPublishing, empty when not compressed
+		want string
+	}{
+		{name: "no compressor", compress: nil, want: ""},
+		{name: "gzip", compress: GzipCompressor, want: "gzip"},
+		{name: "deflate", compress: DeflateCompressor, want: "deflate"},
+		{name: "below threshold", compress: GzipCompressor, threshold: len(body) + 1, want: ""},
+		{name: "equal to threshold", compress: GzipCompressor, threshold: len(body), want: "gzip"},
+		{name: "already encoded", compress: GzipCompressor, encoding: "identity", want: "identity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := compressPublishing(tt.compress, tt.threshold, amqp.Publishing{ContentEncoding: tt.encoding, Body: body})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.ContentEncoding != tt.want {
+				t.Errorf("content encoding = %q, want %q", msg.ContentEncoding, tt.want)
+			}
+			compressed := tt.want != "" && tt.want != tt.encoding
+			if compressed == bytes.Equal(msg.Body, body) {
+				t.Errorf("body compressed = %v, want %v", !compressed, compressed)
+			}
+
+			delivery := amqp.Delivery{ContentEncoding: msg.ContentEncoding, Body: msg.Body}
+			if err := decompressDelivery(&delivery); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(delivery.Body, body) {
+				t.Errorf("round trip body = %q, want %q", delivery.Body, body)
+			}
+			if compressed && delivery.ContentEncoding != "" {
+				t.Errorf("content encoding = %q after decompress, want empty", delivery.ContentEncoding)
+			}
+		})
+	}
+}
+
+func TestDecompressDelivery(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		wantErr  bool
+	}{
+		{name: "not encoded", body: []byte("abc")},
+		{name: "unknown encoding", encoding: "br", body: []byte("abc")},
+		{name: "corrupt gzip", encoding: "gzip", body: []byte("abc"), wantErr: true},
+		{name: "corrupt deflate", encoding: "DEFLATE", body: []byte{0xff, 0xff}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delivery := amqp.Delivery{ContentEncoding: tt.encoding, Body: tt.body}
+			err := decompressDelivery(&delivery)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decompressDelivery() = %q, want error", delivery.Body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(delivery.Body, tt.body) || delivery.ContentEncoding != tt.encoding {
+				t.Errorf("delivery changed to %q %q", delivery.ContentEncoding, delivery.Body)
+			}
+		})
+	}
+}
@@ -10,10 +10,12 @@ type Connection struct {
 	connection    *amqp.Connection
 	idleChannels  []*Channel
 	usedChannels  map[string]*Channel
+	txChannels    []*Channel //idle channels in tx mode
 	mtype         MQType
 	lock          int32
 	tag           int
 	closeHandlers []func(error *amqp.Error)
+	topology      *topologyCache
 }
 
 //non-thread-safe
@@ -25,32 +27,40 @@ func (conn *Connection) close() {
 	}
 	conn.usedChannels = nil
 	conn.idleChannels = nil
+	conn.txChannels = nil
 	conn.lock = 0
 	conn.connection = nil
 	conn.closeHandlers = nil
+	conn.topology.reset()
 }
 
 func (conn *Connection) createNewConsumerChannel() (*Channel, error) {
-	channel, err := conn.connection.Channel()
-	if err != nil {
-		return nil, err
-	}
-	ch := &Channel {
-		channel: channel,
-		connTag: conn.tag,
-	}
-	return ch, nil
+	return conn.createNewChannel()
 }
 
 func (conn *Connection) createNewProducerChannel() (*Channel, error) {
+	return conn.createNewChannel()
+}
+
+func (conn *Connection) createNewChannel() (*Channel, error) {
 	channel, err := conn.connection.Channel()
 	if err != nil {
 		return nil, err
 	}
-	return &Channel{
-		channel: channel,
-		connTag: conn.tag,
-	}, nil
+	ch := &Channel{
+		channel:  channel,
+		connTag:  conn.tag,
+		topology: conn.topology,
+	}
+	go func() {
+		//channel被服务器异常关闭(例如声明的参数冲突)时, 已声明的topology不再可信
+		//正常关闭不会收到close通知
+		for amqpErr := range channel.NotifyClose(make(chan *amqp.Error, 1)) {
+			log.Logger.Info("channel of connection ", conn.tag, " closed: ", amqpErr.Error())
+			ch.topology.reset()
+		}
+	}()
+	return ch, nil
 }
 
 func (conn *Connection) shutdownConn(key string) error {
@@ -109,6 +119,34 @@ func (conn *Connection) shutdownIfNeeded(key string, mqType MQType, co *Consumer
 	}
 }
 
+//从used pool中移除并关闭channel, 不会放回idle pool
+func (conn *Connection) discard(key string, mqType MQType) error {
+	err := lock(&conn.lock)
+	if err != nil { return err }
+
+	defer func() { conn.lock = 0 }()
+	if c := conn.usedChannels[key]; c != nil {
+		delete(conn.usedChannels, key)
+		c.close(mqType, nil)
+		return nil
+	}
+	return fmt.Errorf("the key does not exist")
+}
+
+//关闭一个失败的channel, 只有channel仍然被自己占用时才从used pool移除
+func (conn *Connection) discardChannel(ch *Channel, mqType MQType) {
+	err := lock(&conn.lock)
+	if err != nil {
+		log.Logger.Error("discard channel - connection ", conn.tag, " ", err.Error())
+	} else {
+		if conn.usedChannels[ch.tag] == ch {
+			delete(conn.usedChannels, ch.tag)
+		}
+		conn.lock = 0
+	}
+	ch.close(mqType, nil)
+}
+
 func (conn *Connection) IsClosed() bool {
 	return conn.connection.IsClosed()
 }
@@ -147,11 +185,11 @@ func (conn *Connection) doConsume(ch *Channel, s Session, qos int, handler func(
 	return ch.consumer(s.ConsumerOptions, s.BindingOptions, s.Queue, qos, handler)
 }
 
-func (conn *Connection) doPublish(ch *Channel, s Session, body []byte) error {
+func (conn *Connection) doPublish(ch *Channel, s Session, routingKey string, msg amqp.Publishing) error {
 	if err := conn.occupied(ch, true, true); err != nil {//put into used pool
 		return err
 	}
-	return ch.publish(s.Exchange, s.BindingOptions, body)
+	return ch.publish(s.Exchange, routingKey, msg)
 }
 
 func (conn *Connection) occupied(ch *Channel, t bool, lockc bool) error {
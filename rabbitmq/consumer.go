@@ -6,6 +6,7 @@ import (
 	"RabbitmqConnectionDispatcher/common/log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -17,6 +18,21 @@ type Consumer struct {
 	HandlerClosed bool //default true
 	QOS           int
 	Tag           string
+	codec         Codec
+	reassembly    *reassembler
+	concurrency   int
+	keyFunc       KeyFunc
+	retryTopology *RetryTopology
+	middlewares   []Middleware
+	quarantine    *quarantine
+	pause         pauseControl
+	drained       func(paused bool)
+
+	reconnect *reconnectPolicy
+	state     int32
+	stopping  int32
+	stopMu    sync.Mutex //保护stopping的重置和stopCh
+	stopCh    chan struct{}
 }
 
 func NewConsumer(e Exchange, q Queue, bo BindingOptions, co ConsumerOptions, tag string) *Consumer {
@@ -37,7 +53,66 @@ func (c *Consumer) Qos(prefetchCount int) {
 	c.QOS = prefetchCount
 }
 
+//合并producer SetChunkSize拆分的消息, 所有分片收齐后handler才会被调用, ack/nack作用于全部分片
+//timeout内没有收齐或者缓存超过maxBytes的消息会被重新入队, 0表示不限制
+//maxChunks是一条消息最多的分片数, Consume时qos至少会调到maxChunks, 超过的消息被nack
+func (c *Consumer) SetReassembly(timeout time.Duration, maxBytes int, maxChunks int) error {
+	if maxChunks <= 0 {
+		return fmt.Errorf("reassembly max chunks must be positive")
+	}
+	c.reassembly = newReassembler(timeout, maxBytes, maxChunks, c.session.ConsumerOptions.AutoAck)
+	return nil
+}
+
+//在BindingOptions之外增加queue和exchange的绑定, 例如多个topic pattern或者HeadersBinding
+func (c *Consumer) AddBinding(bo ...BindingOptions) {
+	c.session.Bindings = append(c.session.Bindings, bo...)
+}
+
+//把其他exchange绑定到consumer的exchange上
+func (c *Consumer) AddExchangeBinding(eb ...ExchangeBinding) {
+	c.session.ExchangeBindings = append(c.session.ExchangeBindings, eb...)
+}
+
+//使用n个goroutine并发处理消息, qos需要相应调大
+func (c *Consumer) Concurrency(n int) {
+	c.concurrency = n
+}
+
+//开启后相同key的消息总是交给同一个goroutine按顺序处理, 例如HeaderKey("device")
+func (c *Consumer) KeyOrdered(keyFunc KeyFunc) {
+	c.keyFunc = keyFunc
+}
+
+//阻塞直到consumer被关闭, 注册了自动重连时连接断开后会自动恢复消费
 func (c *Consumer) Consume(handler func(delivery amqp.Delivery)) error {
+	if c.quarantine != nil {
+		handler = c.quarantine.wrap(handler)
+	}
+	if c.reassembly != nil {
+		handler = c.reassembly.wrap(handler)
+		//qos小于分片数时未确认的分片占满qos, 永远收不齐
+		if c.QOS < c.reassembly.maxChunks {
+			log.Logger.Info("consumer ", c.Tag, " qos raised to ", c.reassembly.maxChunks, " for reassembly")
+			c.QOS = c.reassembly.maxChunks
+		}
+	}
+	//Pause需要用consumer tag取消消费, 没有设置时生成一个
+	if c.session.ConsumerOptions.Tag == "" {
+		c.session.ConsumerOptions.Tag = c.Tag + "-" + newMessageID()
+	}
+	stopCh := c.resetStop()
+	registerConsumer(c)
+	defer unregisterConsumer(c)
+	if c.reconnect != nil {
+		return c.supervise(handler, stopCh)
+	}
+	c.setState(ConsumerRunning)
+	defer c.setState(ConsumerStopped)
+	return c.consume(handler)
+}
+
+func (c *Consumer) consume(handler func(delivery amqp.Delivery)) error {
 	pool.mu.Lock()
 	conn := pool.chooseIdleConnection(MQTypeConsumer)
 	if conn == nil {
@@ -109,6 +184,17 @@ func (c *Consumer) Consume(handler func(delivery amqp.Delivery)) error {
 	}
 }
 
+//newValue返回解码的目标对象(指针), 根据delivery的content type选择codec
+//没有content type或者为text/plain(Publish发送的消息)时使用SetCodec设置的codec, 默认为JSONCodec
+//无法解码的消息会被nack
+func (c *Consumer) ConsumeValue(newValue func() interface{}, handler func(v interface{}, delivery amqp.Delivery)) error {
+	return c.Consume(typedHandler(c.session.ConsumerOptions.AutoAck, c.codec, newValue, handler))
+}
+
+func (c *Consumer) SetCodec(codec Codec) {
+	c.codec = codec
+}
+
 func (c *Consumer) handlerClosedError(conn *Connection) {
 	if c.HandlerClosed == true && c.closeHandler != nil {
 		conn.closeHandlers = append(conn.closeHandlers, c.closeHandler)
@@ -117,6 +203,8 @@ func (c *Consumer) handlerClosedError(conn *Connection) {
 
 //可能会将connection和channel放入连接池中
 func (c *Consumer) Shutdown() error {
+	c.stop()
+	if c.channel == nil { return fmt.Errorf("consumer shutdown error") }
 	err := shutdownChannel(c.channel.channel, c.session.ConsumerOptions.Tag)
 	if err != nil { return err }
 
@@ -136,6 +224,8 @@ func (c *Consumer) Shutdown() error {
 
 //完全断开conn和channel
 func (c *Consumer) Close() error {
+	c.stop()
+	if c.channel == nil { return fmt.Errorf("consumer shutdown error") }
 	err := shutdownChannel(c.channel.channel, c.session.ConsumerOptions.Tag)
 	if err != nil { log.Logger.Error(err) }
 	defer log.Logger.Info("Consumer shutdown OK")
@@ -163,80 +253,80 @@ func (c *Consumer) bind(ch *Channel) error {
 	q := c.session.Queue
 	bo := c.session.BindingOptions
 
-	var err error
 	// declaring Exchange
-	if err1 := c.channel.channel.ExchangeDeclare(
-		e.Name,       // name of the exchange
-		e.Type,       // type
-		e.Durable,    // durable
-		e.AutoDelete, // delete when complete
-		e.Internal,   // internal
-		e.NoWait,     // noWait
-		e.Args,       // arguments
-	); err1 != nil {
-		return err1
+	if err := c.channel.declareExchange(e); err != nil {
+		return err
 	}
 
 	// declaring Queue
-	_, err1 := c.channel.channel.QueueDeclare(
-		q.Name,       // name of the queue
-		q.Durable,    // durable
-		q.AutoDelete, // delete when usused
-		q.Exclusive,  // exclusive
-		q.NoWait,     // noWait
-		q.Args,       // arguments
-	)
-	if err1 != nil {
-		return err1
+	if err := c.channel.declareQueue(q); err != nil {
+		return err
 	}
 
 	// binding Exchange to Queue
-	if err = c.channel.channel.QueueBind(
-		// bind to real queue
-		q.Name,        // name of the queue
-		bo.RoutingKey, // bindingKey
-		e.Name,        // sourceExchange
-		bo.NoWait,     // noWait
-		bo.Args,       // arguments
-	); err != nil {
-		return err
+	//只通过AddBinding设置绑定时跳过空的BindingOptions, 否则headers exchange上会匹配所有消息
+	if !(len(c.session.Bindings) > 0 && bo.RoutingKey == "" && len(bo.Args) == 0) {
+		if err := c.channel.bindQueue(q, e, bo); err != nil {
+			return err
+		}
+	}
+	for _, b := range c.session.Bindings {
+		if err := c.channel.bindQueue(q, e, b); err != nil {
+			return err
+		}
+	}
+
+	// binding source Exchanges to Exchange
+	for _, eb := range c.session.ExchangeBindings {
+		if err := c.channel.bindExchange(e, eb); err != nil {
+			return err
+		}
+	}
+
+	// declaring quarantine queue
+	if c.quarantine != nil {
+		if err := c.quarantine.declare(c.channel); err != nil {
+			return err
+		}
+	}
+
+	// declaring retry and dead letter queues
+	if c.retryTopology != nil {
+		if err := c.retryTopology.declare(c.channel, q); err != nil {
+			return err
+		}
 	}
 
 	c.channel.tag = c.channelKey()
+	c.channel.workers = c.concurrency
+	c.channel.keyFunc = c.keyFunc
+	c.channel.pause = &c.pause
+	c.channel.drained = c.drained
 	return nil
 }
 
+//包含consumer Tag, 同一个queue的多个consumer(例如ConsumerGroup)可以在同一个connection上各自占用一个channel
 func (c *Consumer) channelKey() string {
-	return c.session.Exchange.Name + c.session.BindingOptions.RoutingKey + c.session.Queue.Name
+	return c.session.Exchange.Name + c.session.BindingOptions.RoutingKey + c.session.Queue.Name + c.Tag
 }
 
-//需要在connection异常时自动重连调用这个方法 retryTimes: 0为永远尝试断连
+//需要在connection异常时自动重连调用这个方法 after: 首次重连间隔(秒), retryTimes: 0为永远尝试断连
+//重连间隔指数增长, 最长5分钟
 func (c *Consumer) RegisterAutoReconnection(after time.Duration, retryTimes int) {
-	c.RegisterClosedHandler(func(error *amqp.Error) {
-		c.retryConnection(after, retryTimes)
-	})
+	base := after * time.Second
+	max := 5 * time.Minute
+	if max < base {
+		max = base
+	}
+	c.RegisterAutoReconnectionWithBackoff(base, max, retryTimes)
 }
 
-func (c *Consumer) retryConnection(after time.Duration, retryTimes int) {
-	rt := 1
-	copyHandler := c.channel.handler
-	c.closeHandler = nil
-	go func() {
-		for {
-			time.Sleep(after * time.Second)
-			log.Logger.Info("retry connect ", c.session.BindingOptions.RoutingKey, " ", rt, " times")
-			rt++
-			c.HandlerClosed = false//不处理close通知
-			if err := c.Consume(copyHandler); err != nil {
-				log.Logger.Error(err)
-			}
-			if err := c.Close(); err != nil {
-				log.Logger.Error(err)
-			}
-			if retryTimes != FOREVER && retryTimes < rt { break }
-		}
-		log.Logger.Info("give up retry connect ", c.session.BindingOptions.RoutingKey)
-	}()
+func (c *Consumer) RegisterAutoReconnectionWithBackoff(base, max time.Duration, retryTimes int) {
+	c.reconnect = &reconnectPolicy{
+		base:       base,
+		max:        max,
+		retryTimes: retryTimes,
+	}
 }
 
 func (c *Consumer) ConnectionTag() int {
@@ -246,20 +336,17 @@ func (c *Consumer) ConnectionTag() int {
 	return -1
 }
 
+//收到退出信号时只停止这个consumer(等待in-flight的handler), 不退出进程
+//进程的退出由common/lifecycle负责, 应用中应该优先使用lifecycle和ShutdownConsumers
 func (c *Consumer) RegisterSignalHandler() {
 	go func() {
 		signals := make(chan os.Signal, 1)
-		signal.Notify(signals)
-		for {
-			signal := <-signals
-			switch signal {
-			case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGSTOP:
-				err := c.Shutdown()
-				if err != nil {
-					panic(err)
-				}
-				os.Exit(1)
-			}
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+		s := <-signals
+		signal.Stop(signals)
+		log.Logger.Info("consumer ", c.Tag, " receive signal ", s.String(), ", shutting down")
+		if err := drainConsumer(c); err != nil {
+			log.Logger.Error("consumer ", c.Tag, " shutdown error: ", err.Error())
 		}
 	}()
 }
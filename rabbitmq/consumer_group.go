@@ -0,0 +1,250 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"sync"
+	"time"
+)
+
+var ErrConsumerGroupStopped = errors.New("consumer group stopped")
+
+type ConsumerGroupOptions struct {
+	// Number of consumer channels, Max is also capped by the consumer channels the pool can open
+	Min int
+	Max int
+
+	// Add a consumer when the depth of the queue is above ScaleUpDepth per consumer
+	ScaleUpDepth int
+
+	// Remove a consumer when the depth is at or below ScaleDownDepth
+	ScaleDownDepth int
+
+	// Time between two checks, at most one consumer is added or removed per check
+	Interval time.Duration
+}
+
+//一个queue的一组consumer, 根据queue的堆积数量在Min和Max之间增减consumer channel
+type ConsumerGroup struct {
+	session   Session
+	tag       string
+	opts      ConsumerGroupOptions
+	configure func(c *Consumer)
+
+	mu      sync.Mutex
+	members []*Consumer
+	seq     int
+	start   func(c *Consumer) error
+	exited  chan *Consumer
+	stopCh  chan struct{}
+}
+
+func NewConsumerGroup(e Exchange, q Queue, bo BindingOptions, co ConsumerOptions, tag string, opts ConsumerGroupOptions) *ConsumerGroup {
+	if opts.Min < 1 {
+		opts.Min = 1
+	}
+	if opts.Max < opts.Min {
+		opts.Max = opts.Min
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	return &ConsumerGroup{
+		session: Session{
+			Exchange:        e,
+			Queue:           q,
+			BindingOptions:  bo,
+			ConsumerOptions: co,
+		},
+		tag:  tag,
+		opts: opts,
+	}
+}
+
+// Configure is called for every new consumer before it starts consuming,
+// e.g. to set Qos, middlewares or auto reconnection
+func (g *ConsumerGroup) Configure(fn func(c *Consumer)) {
+	g.configure = fn
+}
+
+// Consume blocks until Stop, every consumer uses the same handler
+func (g *ConsumerGroup) Consume(handler func(delivery amqp.Delivery)) error {
+	return g.run(func(c *Consumer) error {
+		return c.Consume(handler)
+	})
+}
+
+func (g *ConsumerGroup) ConsumeFunc(handler HandlerFunc) error {
+	return g.run(func(c *Consumer) error {
+		return c.ConsumeFunc(handler)
+	})
+}
+
+func (g *ConsumerGroup) Size() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.members)
+}
+
+// Stop drains and shuts down every consumer, Consume returns afterwards
+func (g *ConsumerGroup) Stop() error {
+	g.mu.Lock()
+	if g.stopCh == nil {
+		g.mu.Unlock()
+		return ErrConsumerGroupStopped
+	}
+	close(g.stopCh)
+	g.stopCh = nil
+	members := g.members
+	g.members = nil
+	g.mu.Unlock()
+
+	var err error
+	for _, c := range members {
+		if err1 := drainConsumer(c); err1 != nil {
+			err = err1
+		}
+	}
+	return err
+}
+
+//pool最多能打开的consumer channel数量
+func maxConsumerChannels() int {
+	return MAX_CONSUMER_CHANNEL_PER_CONN * MAX_CONNECTIONS
+}
+
+func (g *ConsumerGroup) run(start func(c *Consumer) error) error {
+	g.mu.Lock()
+	if g.stopCh != nil {
+		g.mu.Unlock()
+		return fmt.Errorf("consumer group %s is already consuming", g.tag)
+	}
+	g.start = start
+	g.exited = make(chan *Consumer, g.opts.Max)
+	g.stopCh = make(chan struct{})
+	stopCh := g.stopCh
+	g.mu.Unlock()
+
+	for i := 0; i < g.opts.Min; i++ {
+		g.scaleUp()
+	}
+	t := time.NewTicker(g.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case c := <-g.exited:
+			g.remove(c)
+		case <-t.C:
+			g.scale()
+		case <-stopCh:
+			return nil
+		}
+	}
+}
+
+func (g *ConsumerGroup) scale() {
+	size := g.Size()
+	//异常退出的consumer先补足到Min
+	if size < g.opts.Min {
+		g.scaleUp()
+		return
+	}
+	var depth int
+	err := borrowChannel(func(ch *Channel) error {
+		q, err := ch.channel.QueueInspect(g.session.Queue.Name)
+		depth = q.Messages
+		return err
+	})
+	if err != nil {
+		log.Logger.Error("consumer group ", g.tag, " inspect queue error: ", err.Error())
+		return
+	}
+	max := g.opts.Max
+	if limit := maxConsumerChannels(); max > limit {
+		max = limit
+	}
+	switch {
+	case g.opts.ScaleUpDepth > 0 && depth > g.opts.ScaleUpDepth*size && size < max:
+		log.Logger.Info("consumer group ", g.tag, " queue depth ", depth, ", scale up to ", size+1)
+		g.scaleUp()
+	case depth <= g.opts.ScaleDownDepth && size > g.opts.Min:
+		log.Logger.Info("consumer group ", g.tag, " queue depth ", depth, ", scale down to ", size-1)
+		g.scaleDown()
+	}
+}
+
+func (g *ConsumerGroup) scaleUp() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.seq++
+	session := g.session
+	tag := fmt.Sprintf("%s-%d", g.tag, g.seq)
+	if session.ConsumerOptions.Tag != "" {
+		session.ConsumerOptions.Tag = fmt.Sprintf("%s-%d", session.ConsumerOptions.Tag, g.seq)
+	}
+	c := NewConsumer(session.Exchange, session.Queue, session.BindingOptions, session.ConsumerOptions, tag)
+	c.AddBinding(session.Bindings...)
+	c.AddExchangeBinding(session.ExchangeBindings...)
+	if g.configure != nil {
+		g.configure(c)
+	}
+	g.members = append(g.members, c)
+
+	start, exited := g.start, g.exited
+	go func() {
+		if err := start(c); err != nil {
+			log.Logger.Error("consumer ", c.Tag, " of group ", g.tag, " exit: ", err.Error())
+		}
+		exited <- c
+	}()
+}
+
+//移除最后加入的consumer, 等待它处理完in-flight的消息
+func (g *ConsumerGroup) scaleDown() {
+	g.mu.Lock()
+	if len(g.members) == 0 {
+		g.mu.Unlock()
+		return
+	}
+	c := g.members[len(g.members)-1]
+	if c.channel == nil {
+		//还没有开始消费, 无法取消, 下一次检查时再移除
+		g.mu.Unlock()
+		log.Logger.Info("consumer ", c.Tag, " of group ", g.tag, " is not consuming yet, scale down on the next check")
+		return
+	}
+	g.members = g.members[:len(g.members)-1]
+	g.mu.Unlock()
+	if err := drainConsumer(c); err != nil {
+		log.Logger.Error("consumer ", c.Tag, " of group ", g.tag, " shutdown error: ", err.Error())
+	}
+}
+
+func (g *ConsumerGroup) remove(c *Consumer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, m := range g.members {
+		if m == c {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+}
+
+//先取消消费等待handler返回, 再关闭consumer
+//Shutdown会关闭amqp channel后再放回idle pool, 之后的consumer会拿到失效的channel,
+//所以这里直接丢弃channel并清空topology缓存
+func drainConsumer(c *Consumer) error {
+	if err := c.Pause(); err != nil && err != ErrConsumerNotRunning {
+		log.Logger.Error("drain consumer ", c.Tag, " error: ", err.Error())
+	}
+	c.stop()
+	if c.channel == nil {
+		return fmt.Errorf("consumer %s has no channel", c.Tag)
+	}
+	c.release()
+	log.Logger.Info("consumer ", c.Tag, " drained")
+	return nil
+}
@@ -0,0 +1,146 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/cache/lru"
+	"RabbitmqConnectionDispatcher/common/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type IdempotencyState int
+
+const (
+	// The key was not seen, it is now claimed as processing
+	IdempotencyNew IdempotencyState = iota
+	// Another delivery with the key is being processed, or its handler died
+	// and the claim has not expired yet
+	IdempotencyProcessing
+	// A delivery with the key was processed successfully
+	IdempotencyDone
+)
+
+// IdempotencyStore remembers the keys of processed deliveries.
+// rabbitmq/redisstore.IdempotencyStore is a redis backed implementation.
+type IdempotencyStore interface {
+	// Claim marks an unknown key as processing for ttl and returns IdempotencyNew,
+	// otherwise it returns the current state of the key
+	Claim(key string, ttl time.Duration) (IdempotencyState, error)
+	// Done marks key as processed for ttl
+	Done(key string, ttl time.Duration) error
+	// Forget removes the mark, so a failed delivery can be processed again
+	Forget(key string) error
+}
+
+var ErrDuplicateInProgress = errors.New("duplicate delivery is being processed")
+
+// MessageID is the default key of DedupeMiddleware
+func MessageID(delivery amqp.Delivery) string {
+	return delivery.MessageId
+}
+
+// DedupeMiddleware skips deliveries whose key was processed within ttl, they are
+// acked by the consumer and counted in Metrics.Duplicates. keyFunc defaults to
+// MessageID, deliveries with an empty key are not deduplicated.
+// A key is claimed for processingTTL while the handler runs and marked done only
+// when it succeeds. A delivery whose key is still claimed is requeued, so a
+// redelivery after a crash is processed once the claim expires. processingTTL
+// must be longer than the handler takes.
+func DedupeMiddleware(store IdempotencyStore, ttl time.Duration, processingTTL time.Duration, keyFunc KeyFunc) Middleware {
+	if keyFunc == nil {
+		keyFunc = MessageID
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, delivery amqp.Delivery) error {
+			key := keyFunc(delivery)
+			if key == "" {
+				return next(ctx, delivery)
+			}
+			state, err := store.Claim(key, processingTTL)
+			if err != nil {
+				//store不可用时照常处理
+				log.Logger.Error("idempotency store claim ", key, " error: ", err.Error())
+				return next(ctx, delivery)
+			}
+			switch state {
+			case IdempotencyDone:
+				log.Logger.Info("skip duplicate delivery ", key)
+				atomic.AddUint64(&metrics.Duplicates, 1)
+				return nil
+			case IdempotencyProcessing:
+				//稍等再重新入队, 避免在claim过期前反复投递
+				t := time.NewTimer(dedupeRequeueDelay)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+				}
+				return Requeue(ErrDuplicateInProgress)
+			}
+			err = next(ctx, delivery)
+			if err != nil {
+				if err1 := store.Forget(key); err1 != nil {
+					log.Logger.Error("idempotency store forget ", key, " error: ", err1.Error())
+				}
+				return err
+			}
+			if err1 := store.Done(key, ttl); err1 != nil {
+				log.Logger.Error("idempotency store done ", key, " error: ", err1.Error())
+			}
+			return nil
+		}
+	}
+}
+
+const dedupeRequeueDelay = time.Second
+
+//进程内的IdempotencyStore, 只能对同一个进程的重复投递去重
+type lruIdempotencyStore struct {
+	mu    sync.Mutex
+	cache *lru.SafeCache
+}
+
+// NewLRUIdempotencyStore keeps at most size keys, the ttl is rounded up to seconds
+func NewLRUIdempotencyStore(size int) (IdempotencyStore, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &lruIdempotencyStore{cache: cache}, nil
+}
+
+func (s *lruIdempotencyStore) Claim(key string, ttl time.Duration) (IdempotencyState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.cache.Get(key); ok {
+		return v.(IdempotencyState), nil
+	}
+	s.cache.AddWithExpired(key, IdempotencyProcessing, ttlSeconds(ttl))
+	return IdempotencyNew, nil
+}
+
+func (s *lruIdempotencyStore) Done(key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.AddWithExpired(key, IdempotencyDone, ttlSeconds(ttl))
+	return nil
+}
+
+//SafeCache的过期时间是秒, 向上取整
+func ttlSeconds(ttl time.Duration) int64 {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+func (s *lruIdempotencyStore) Forget(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.Remove(key)
+	return nil
+}
@@ -0,0 +1,116 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestLRUIdempotencyStore(t *testing.T) {
+	type step struct {
+		op   string
+		want IdempotencyState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{name: "claim new key", steps: []step{{op: "claim", want: IdempotencyNew}}},
+		{name: "claim twice", steps: []step{{op: "claim", want: IdempotencyNew}, {op: "claim", want: IdempotencyProcessing}}},
+		{name: "done", steps: []step{{op: "claim", want: IdempotencyNew}, {op: "done"}, {op: "claim", want: IdempotencyDone}}},
+		{name: "forget after failure", steps: []step{{op: "claim", want: IdempotencyNew}, {op: "forget"}, {op: "claim", want: IdempotencyNew}}},
+		{name: "forget done", steps: []step{{op: "done"}, {op: "forget"}, {op: "claim", want: IdempotencyNew}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewLRUIdempotencyStore(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range tt.steps {
+				switch s.op {
+				case "claim":
+					got, err := store.Claim("key", time.Minute)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != s.want {
+						t.Errorf("step %d claim = %d, want %d", i, got, s.want)
+					}
+				case "done":
+					if err := store.Done("key", time.Minute); err != nil {
+						t.Fatal(err)
+					}
+				case "forget":
+					if err := store.Forget("key"); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDedupeMiddleware(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name string
+		// claimed before the deliveries, as if another consumer is processing it
+		claimed bool
+		// results of the handler for each delivery
+		results []error
+		// whether the handler is called for each delivery
+		wantCalls   []bool
+		wantRequeue []bool
+	}{
+		{
+			name:        "duplicate is skipped",
+			results:     []error{nil, nil},
+			wantCalls:   []bool{true, false},
+			wantRequeue: []bool{false, false},
+		},
+		{
+			name:        "failed delivery is processed again",
+			results:     []error{errFailed, nil, nil},
+			wantCalls:   []bool{true, true, false},
+			wantRequeue: []bool{false, false, false},
+		},
+		{
+			name:        "in progress is requeued",
+			claimed:     true,
+			results:     []error{nil},
+			wantCalls:   []bool{false},
+			wantRequeue: []bool{true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewLRUIdempotencyStore(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.claimed {
+				store.Claim("id", time.Minute)
+			}
+			//已取消的ctx跳过重新入队前的等待
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			for i, result := range tt.results {
+				called := false
+				handler := DedupeMiddleware(store, time.Minute, time.Minute, nil)(func(ctx context.Context, delivery amqp.Delivery) error {
+					called = true
+					return result
+				})
+				err := handler(ctx, amqp.Delivery{MessageId: "id"})
+				if called != tt.wantCalls[i] {
+					t.Errorf("delivery %d handler called = %v, want %v", i, called, tt.wantCalls[i])
+				}
+				if IsRequeue(err) != tt.wantRequeue[i] {
+					t.Errorf("delivery %d error = %v, want requeue %v", i, err, tt.wantRequeue[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//每个延迟tier对应一个fanout exchange和一个带TTL的queue, 消息过期后dead letter回原来的exchange
+//routing key保持不变, 所以不需要rabbitmq_delayed_message_exchange插件
+type delayTiers struct {
+	mu        sync.Mutex
+	tiers     []time.Duration
+	producers map[time.Duration]*Producer
+}
+
+// DefaultDelayTiers are used when SetDelayTiers is not called, so computed
+// delays do not declare a new exchange and queue per millisecond value
+var DefaultDelayTiers = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+}
+
+//delay会向上取整到最近的tier, 不设置时使用DefaultDelayTiers, 超过最大tier的delay返回错误
+func (p *Producer) SetDelayTiers(tiers ...time.Duration) {
+	sorted := make([]time.Duration, 0, len(tiers))
+	for _, t := range tiers {
+		if t > 0 {
+			sorted = append(sorted, t)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p.delay.setTiers(sorted)
+}
+
+//tier的channel在返回前放回pool, 调用者不需要Shutdown
+func (p *Producer) PublishDelayed(body []byte, delay time.Duration) error {
+	tp := p
+	if delay > 0 {
+		var err error
+		if tp, err = p.delay.producer(p, delay); err != nil {
+			return err
+		}
+	}
+	conn, err := tp.Publish(body)
+	if conn != nil {
+		if err1 := tp.Shutdown(conn); err1 != nil {
+			log.Logger.Error(err1.Error())
+		}
+	}
+	return err
+}
+
+func newDelayTiers() *delayTiers {
+	return &delayTiers{
+		producers: make(map[time.Duration]*Producer),
+	}
+}
+
+func (dt *delayTiers) setTiers(tiers []time.Duration) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.tiers = tiers
+}
+
+func (dt *delayTiers) tier(delay time.Duration) (time.Duration, error) {
+	tiers := dt.tiers
+	if len(tiers) == 0 {
+		tiers = DefaultDelayTiers
+	}
+	for _, t := range tiers {
+		if t >= delay {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("delay %s exceeds the largest delay tier %s", delay, tiers[len(tiers)-1])
+}
+
+//tier producer复制了原producer的codec, 压缩, 重试和限流配置
+func (dt *delayTiers) producer(p *Producer, delay time.Duration) (*Producer, error) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	tier, err := dt.tier(delay)
+	if err != nil {
+		return nil, err
+	}
+	if tp := dt.producers[tier]; tp != nil {
+		return tp, nil
+	}
+
+	name := delayTierName(p.session.Exchange.Name, tier)
+	tp := *p
+	tp.channel = nil
+	tp.session.Exchange = Exchange{
+		Name:    name,
+		Type:    amqp.ExchangeFanout,
+		Durable: true,
+	}
+	tag := name
+	if p.tag != nil {
+		tag = *p.tag + name
+	}
+	tp.tag = &tag
+	queue := Queue{
+		Name:    name,
+		Durable: true,
+		Args: amqp.Table{
+			"x-message-ttl":          int64(tier / time.Millisecond),
+			"x-dead-letter-exchange": p.session.Exchange.Name,
+		},
+	}
+	tp.topology = func(ch *Channel) error {
+		if err := ch.declareQueue(queue); err != nil {
+			return err
+		}
+		return ch.bindQueue(queue, tp.session.Exchange, BindingOptions{})
+	}
+	dt.producers[tier] = &tp
+	return &tp, nil
+}
+
+func delayTierName(exchange string, tier time.Duration) string {
+	if exchange == "" {
+		exchange = "default"
+	}
+	return exchange + ".delay." + strconv.FormatInt(int64(tier/time.Millisecond), 10)
+}
@@ -0,0 +1,73 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestDelayTier(t *testing.T) {
+	tests := []struct {
+		name    string
+		tiers   []time.Duration
+		delay   time.Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default exact", delay: 5 * time.Second, want: 5 * time.Second},
+		{name: "default rounded up", delay: 1500 * time.Millisecond, want: 5 * time.Second},
+		{name: "default too large", delay: 2 * time.Hour, wantErr: true},
+		{name: "custom", tiers: []time.Duration{time.Minute, 10 * time.Second}, delay: 20 * time.Second, want: time.Minute},
+		{name: "custom too large", tiers: []time.Duration{10 * time.Second}, delay: time.Minute, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProducer(Exchange{Name: "delay"}, BindingOptions{})
+			p.SetDelayTiers(tt.tiers...)
+			got, err := p.delay.tier(tt.delay)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("tier() = %s, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tier() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("tier() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishDelayedSameTier(t *testing.T) {
+	initTestPool(t)
+	tests := []struct {
+		name   string
+		delays []time.Duration
+	}{
+		{name: "same delay", delays: []time.Duration{time.Second, time.Second}},
+		{name: "rounded to the same tier", delays: []time.Duration{300 * time.Millisecond, 900 * time.Millisecond}},
+		{name: "no delay", delays: []time.Duration{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exchange := Exchange{Name: "test.delay", Type: amqp.ExchangeDirect, AutoDelete: true}
+			p := NewSafeProducer(exchange, BindingOptions{RoutingKey: "delay"}, "delay-test")
+			for i, delay := range tt.delays {
+				if err := p.PublishDelayed([]byte("delayed"), delay); err != nil {
+					t.Fatalf("PublishDelayed #%d error: %v", i+1, err)
+				}
+			}
+			if channelInUse(p.channelKey()) {
+				t.Errorf("channel of the producer is not released")
+			}
+			for tier, tp := range p.delay.producers {
+				if channelInUse(tp.channelKey()) {
+					t.Errorf("channel of the %s tier is not released", tier)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"hash/fnv"
+	"sync"
+)
+
+// KeyFunc derives the ordering key of a delivery, deliveries with the same
+// key are handled by the same goroutine in order
+type KeyFunc func(delivery amqp.Delivery) string
+
+// HeaderKey uses the value of a header as ordering key, e.g. the device id
+func HeaderKey(header string) KeyFunc {
+	return func(delivery amqp.Delivery) string {
+		switch v := delivery.Headers[header].(type) {
+		case string:
+			return v
+		case []byte:
+			return string(v)
+		case nil:
+			return ""
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+}
+
+// RoutingKey uses the routing key of the delivery as ordering key
+func RoutingKey(delivery amqp.Delivery) string {
+	return delivery.RoutingKey
+}
+
+//deliveries关闭后等待所有worker处理完再返回
+func (ch *Channel) dispatch(autoAck bool, handler func(delivery amqp.Delivery)) {
+	handle := func(delivery amqp.Delivery) {
+		//压缩过的消息先解压再交给handler, 分片在合并后再解压
+		if _, chunked := delivery.Headers[HeaderChunkID]; chunked {
+			handler(delivery)
+			return
+		}
+		if err := decompressDelivery(&delivery); err != nil {
+			log.Logger.Error("decompress delivery ", delivery.DeliveryTag, " error: ", err.Error())
+			if !autoAck {
+				delivery.Nack(false, false)
+			}
+			return
+		}
+		handler(delivery)
+	}
+
+	if ch.workers <= 1 {
+		for delivery := range ch.deliveries {
+			handle(delivery)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	work := func(q chan amqp.Delivery) {
+		defer wg.Done()
+		for delivery := range q {
+			handle(delivery)
+		}
+	}
+
+	if ch.keyFunc == nil {
+		//所有worker共享一个队列
+		shared := make(chan amqp.Delivery)
+		for i := 0; i < ch.workers; i++ {
+			wg.Add(1)
+			go work(shared)
+		}
+		for delivery := range ch.deliveries {
+			shared <- delivery
+		}
+		close(shared)
+		wg.Wait()
+		return
+	}
+
+	//相同key的消息总是交给同一个worker, 保证顺序
+	queues := make([]chan amqp.Delivery, ch.workers)
+	for i := range queues {
+		queues[i] = make(chan amqp.Delivery)
+		wg.Add(1)
+		go work(queues[i])
+	}
+	for delivery := range ch.deliveries {
+		h := fnv.New32a()
+		h.Write([]byte(ch.keyFunc(delivery)))
+		queues[h.Sum32()%uint32(len(queues))] <- delivery
+	}
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,124 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeaderKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    string
+	}{
+		{name: "string", headers: amqp.Table{"device": "d1"}, want: "d1"},
+		{name: "bytes", headers: amqp.Table{"device": []byte("d2")}, want: "d2"},
+		{name: "number", headers: amqp.Table{"device": int64(3)}, want: "3"},
+		{name: "missing", headers: amqp.Table{"other": "x"}, want: ""},
+		{name: "no headers", headers: nil, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HeaderKey("device")(amqp.Delivery{Headers: tt.headers}); got != tt.want {
+				t.Errorf("HeaderKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatchKeyOrdered(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		keyFunc KeyFunc
+		keys    int
+	}{
+		{name: "single worker", workers: 1, keyFunc: HeaderKey("device"), keys: 3},
+		{name: "header key", workers: 4, keyFunc: HeaderKey("device"), keys: 10},
+		{name: "routing key", workers: 4, keyFunc: RoutingKey, keys: 10},
+		{name: "more workers than keys", workers: 8, keyFunc: HeaderKey("device"), keys: 2},
+		{name: "one key", workers: 4, keyFunc: HeaderKey("device"), keys: 1},
+	}
+	const perKey = 30
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := make(chan amqp.Delivery, tt.keys*perKey)
+			for seq := 0; seq < perKey; seq++ {
+				for k := 0; k < tt.keys; k++ {
+					key := fmt.Sprintf("key-%d", k)
+					in <- amqp.Delivery{
+						RoutingKey: key,
+						Headers:    amqp.Table{"device": key, "seq": int64(seq)},
+					}
+				}
+			}
+			close(in)
+
+			var mu sync.Mutex
+			got := make(map[string][]int64)
+			running := make(map[string]bool)
+			ch := &Channel{deliveries: in, workers: tt.workers, keyFunc: tt.keyFunc}
+			ch.dispatch(false, func(delivery amqp.Delivery) {
+				key := tt.keyFunc(delivery)
+				mu.Lock()
+				if running[key] {
+					t.Errorf("key %s is handled concurrently", key)
+				}
+				running[key] = true
+				mu.Unlock()
+				//让不同key的处理交错
+				time.Sleep(time.Duration(delivery.Headers["seq"].(int64)%3) * 100 * time.Microsecond)
+				mu.Lock()
+				running[key] = false
+				got[key] = append(got[key], delivery.Headers["seq"].(int64))
+				mu.Unlock()
+			})
+
+			//dispatch返回时所有消息都已经处理完
+			if len(got) != tt.keys {
+				t.Fatalf("handled %d keys, want %d", len(got), tt.keys)
+			}
+			for key, seqs := range got {
+				if len(seqs) != perKey {
+					t.Errorf("key %s handled %d deliveries, want %d", key, len(seqs), perKey)
+				}
+				for i, seq := range seqs {
+					if seq != int64(i) {
+						t.Errorf("key %s out of order: %v", key, seqs)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDispatchConcurrency(t *testing.T) {
+	const workers = 4
+	in := make(chan amqp.Delivery, workers)
+	for i := 0; i < workers; i++ {
+		in <- amqp.Delivery{DeliveryTag: uint64(i + 1)}
+	}
+	close(in)
+
+	//没有keyFunc时消息由workers个goroutine并发处理
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	all := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(all)
+	}()
+	ch := &Channel{deliveries: in, workers: workers}
+	ch.dispatch(false, func(delivery amqp.Delivery) {
+		wg.Done()
+		select {
+		case <-all:
+		case <-time.After(time.Second):
+			t.Errorf("delivery %d is not handled concurrently", delivery.DeliveryTag)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"runtime/debug"
+)
+
+// HandlerFunc is acked by the consumer when it returns nil. A returned error
+// is nacked without requeue unless it is wrapped by Requeue, a panic is
+// recovered and treated like an error.
+type HandlerFunc func(ctx context.Context, delivery amqp.Delivery) error
+
+type requeueError struct {
+	err error
+}
+
+func (e *requeueError) Error() string { return e.err.Error() }
+func (e *requeueError) Unwrap() error { return e.err }
+
+type rejectError struct {
+	err error
+}
+
+func (e *rejectError) Error() string { return e.err.Error() }
+func (e *rejectError) Unwrap() error { return e.err }
+
+// Requeue marks err as transient, the delivery is nacked and requeued
+func Requeue(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &requeueError{err: err}
+}
+
+// Reject marks err as permanent, the delivery is rejected without requeue
+// and dead-lettered if the queue has a dead letter exchange
+func Reject(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &rejectError{err: err}
+}
+
+//err可以被fmt.Errorf("%w")或者middleware再次包装
+func IsRequeue(err error) bool {
+	var re *requeueError
+	return errors.As(err, &re)
+}
+
+func IsReject(err error) bool {
+	var re *rejectError
+	return errors.As(err, &re)
+}
+
+// PanicError is the error of a recovered handler panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panic: %v", e.Value)
+}
+
+//和原始的func(amqp.Delivery) handler并存, handler不需要自己ack
+func (c *Consumer) ConsumeFunc(handler HandlerFunc) error {
+	return c.Consume(c.adapt(handler))
+}
+
+func (c *Consumer) adapt(handler HandlerFunc) func(delivery amqp.Delivery) {
+	autoAck := c.session.ConsumerOptions.AutoAck
+	handler = chain(handler, c.middlewares)
+	return func(delivery amqp.Delivery) {
+		err := callHandler(context.Background(), handler, delivery)
+		if err != nil {
+			log.Logger.Error("handle delivery ", delivery.DeliveryTag, " error: ", err.Error())
+		}
+		if autoAck {
+			return
+		}
+		c.settle(delivery, err)
+	}
+}
+
+func (c *Consumer) settle(delivery amqp.Delivery, err error) {
+	if err != nil && c.retryTopology != nil && !IsRequeue(err) {
+		//转发到retry queue或者dead letter queue后ack原消息, 转发失败则重新入队
+		if err1 := c.retryTopology.route(c.session.Queue, delivery, err); err1 != nil {
+			log.Logger.Error("route delivery ", delivery.DeliveryTag, " to retry queue error: ", err1.Error())
+			err = Requeue(err)
+		} else {
+			err = nil
+		}
+	}
+	if err1 := settle(delivery, err); err1 != nil {
+		log.Logger.Error(err1.Error())
+	}
+}
+
+func callHandler(ctx context.Context, handler HandlerFunc, delivery amqp.Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return handler(ctx, delivery)
+}
+
+func settle(delivery amqp.Delivery, err error) error {
+	switch {
+	case err == nil:
+		return delivery.Ack(false)
+	case IsRequeue(err):
+		return delivery.Nack(false, true)
+	case IsReject(err):
+		return delivery.Reject(false)
+	default:
+		return delivery.Nack(false, false)
+	}
+}
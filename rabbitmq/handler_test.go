@@ -0,0 +1,67 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestSettle(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name string
+		err  error
+		want settlement
+	}{
+		{name: "ack", err: nil, want: settlement{tag: 1, ack: true}},
+		{name: "nack", err: errFailed, want: settlement{tag: 1}},
+		{name: "requeue", err: Requeue(errFailed), want: settlement{tag: 1, requeue: true}},
+		{name: "wrapped requeue", err: fmt.Errorf("handle order: %w", Requeue(errFailed)), want: settlement{tag: 1, requeue: true}},
+		{name: "reject", err: Reject(errFailed), want: settlement{tag: 1}},
+		{name: "wrapped reject", err: fmt.Errorf("handle order: %w", Reject(errFailed)), want: settlement{tag: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &recordAcknowledger{}
+			if err := settle(amqp.Delivery{Acknowledger: ack, DeliveryTag: 1}, tt.err); err != nil {
+				t.Fatal(err)
+			}
+			got := ack.settlements()
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("settled %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRequeueIsReject(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name        string
+		err         error
+		wantRequeue bool
+		wantReject  bool
+	}{
+		{name: "nil", err: nil},
+		{name: "plain", err: errFailed},
+		{name: "requeue", err: Requeue(errFailed), wantRequeue: true},
+		{name: "wrapped requeue", err: fmt.Errorf("outer: %w", fmt.Errorf("inner: %w", Requeue(errFailed))), wantRequeue: true},
+		{name: "reject", err: Reject(errFailed), wantReject: true},
+		{name: "wrapped reject", err: fmt.Errorf("outer: %w", Reject(errFailed)), wantReject: true},
+		{name: "unwrapped text", err: fmt.Errorf("outer: %v", Requeue(errFailed))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRequeue(tt.err); got != tt.wantRequeue {
+				t.Errorf("IsRequeue() = %v, want %v", got, tt.wantRequeue)
+			}
+			if got := IsReject(tt.err); got != tt.wantReject {
+				t.Errorf("IsReject() = %v, want %v", got, tt.wantReject)
+			}
+			if tt.wantRequeue && !errors.Is(tt.err, errFailed) {
+				t.Errorf("cause is lost")
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package rabbitmq
+
+import "sync/atomic"
+
+// Metrics are process wide counters of the rabbitmq package
+type Metrics struct {
+	// Messages accepted by the broker, or written to the channel when confirms are off
+	Published uint64
+
+	// Publishes that failed after every retry
+	PublishErrors uint64
+
+	// Publishes that had to wait for a rate limit token
+	Throttled uint64
+
+	// Publishes refused by a fail fast rate limit
+	ThrottleRejected uint64
+
+	// Deliveries handled through MetricsMiddleware, the failed ones and the total handling time
+	Consumed      uint64
+	ConsumeErrors uint64
+	ConsumeNanos  uint64
+
+	// Messages moved into a quarantine queue
+	Quarantined uint64
+
+	// Duplicate deliveries skipped by DedupeMiddleware
+	Duplicates uint64
+
+	// Alerts fired by a QueueMonitor
+	QueueAlerts uint64
+}
+
+var metrics Metrics
+
+// GetMetrics returns a snapshot of the counters
+func GetMetrics() Metrics {
+	return Metrics{
+		Published:        atomic.LoadUint64(&metrics.Published),
+		PublishErrors:    atomic.LoadUint64(&metrics.PublishErrors),
+		Throttled:        atomic.LoadUint64(&metrics.Throttled),
+		ThrottleRejected: atomic.LoadUint64(&metrics.ThrottleRejected),
+		Consumed:         atomic.LoadUint64(&metrics.Consumed),
+		ConsumeErrors:    atomic.LoadUint64(&metrics.ConsumeErrors),
+		ConsumeNanos:     atomic.LoadUint64(&metrics.ConsumeNanos),
+		Quarantined:      atomic.LoadUint64(&metrics.Quarantined),
+		Duplicates:       atomic.LoadUint64(&metrics.Duplicates),
+		QueueAlerts:      atomic.LoadUint64(&metrics.QueueAlerts),
+	}
+}
+
+func countPublish(err error) {
+	if err != nil {
+		atomic.AddUint64(&metrics.PublishErrors, 1)
+	} else {
+		atomic.AddUint64(&metrics.Published, 1)
+	}
+}
@@ -0,0 +1,96 @@
+package rabbitmq
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// Middleware wraps a HandlerFunc, see Consumer.Use
+type Middleware func(next HandlerFunc) HandlerFunc
+
+//按注册顺序由外到内包装handler, 只对ConsumeFunc注册的handler生效
+func (c *Consumer) Use(middleware ...Middleware) {
+	c.middlewares = append(c.middlewares, middleware...)
+}
+
+func chain(handler HandlerFunc, middlewares []Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// LoggingMiddleware logs the routing key, duration and error of every delivery
+func LoggingMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, delivery amqp.Delivery) error {
+			begin := time.Now()
+			err := next(ctx, delivery)
+			if err != nil {
+				log.Logger.Error("handle ", delivery.RoutingKey, " message ", delivery.MessageId, " in ", time.Since(begin), " error: ", err.Error())
+			} else {
+				log.Logger.Info("handle ", delivery.RoutingKey, " message ", delivery.MessageId, " in ", time.Since(begin))
+			}
+			return err
+		}
+	}
+}
+
+// RecoveryMiddleware turns a panic into a *PanicError so outer middlewares see it
+func RecoveryMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, delivery amqp.Delivery) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Value: r, Stack: debug.Stack()}
+					log.Logger.Error("handler panic: ", r, "\n", string(err.(*PanicError).Stack))
+				}
+			}()
+			return next(ctx, delivery)
+		}
+	}
+}
+
+// TimeoutMiddleware cancels the context of the handler after d, handlers must
+// honour ctx to stop in time. The delivery is settled only after the handler
+// returns, so its side effects are not lost and KeyOrdered deliveries keep
+// their order. An error returned after the deadline is requeued.
+func TimeoutMiddleware(d time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, delivery amqp.Delivery) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			begin := time.Now()
+			err := next(ctx, delivery)
+			if ctx.Err() != context.DeadlineExceeded {
+				return err
+			}
+			log.Logger.Error("handle ", delivery.RoutingKey, " message ", delivery.MessageId, " exceeded timeout ", d, ", returned in ", time.Since(begin))
+			//超时的消息重新入队, 明确Reject的除外
+			if err != nil && !IsReject(err) {
+				return Requeue(err)
+			}
+			return err
+		}
+	}
+}
+
+// MetricsMiddleware counts handled deliveries, failures and handling time in Metrics
+func MetricsMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, delivery amqp.Delivery) error {
+			begin := time.Now()
+			err := next(ctx, delivery)
+			atomic.AddUint64(&metrics.Consumed, 1)
+			atomic.AddUint64(&metrics.ConsumeNanos, uint64(time.Since(begin)))
+			if err != nil {
+				atomic.AddUint64(&metrics.ConsumeErrors, 1)
+			}
+			return err
+		}
+	}
+}
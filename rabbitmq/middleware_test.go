@@ -0,0 +1,77 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name        string
+		handler     HandlerFunc
+		wantErr     bool
+		wantRequeue bool
+		wantReject  bool
+	}{
+		{
+			name:    "in time",
+			handler: func(ctx context.Context, delivery amqp.Delivery) error { return nil },
+		},
+		{
+			name:    "error in time",
+			handler: func(ctx context.Context, delivery amqp.Delivery) error { return errFailed },
+			wantErr: true,
+		},
+		{
+			name: "honours ctx",
+			handler: func(ctx context.Context, delivery amqp.Delivery) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			wantErr:     true,
+			wantRequeue: true,
+		},
+		{
+			name: "succeeds after the deadline",
+			handler: func(ctx context.Context, delivery amqp.Delivery) error {
+				<-ctx.Done()
+				return nil
+			},
+		},
+		{
+			name: "rejected after the deadline",
+			handler: func(ctx context.Context, delivery amqp.Delivery) error {
+				<-ctx.Done()
+				return Reject(ctx.Err())
+			},
+			wantErr:    true,
+			wantReject: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := false
+			handler := func(ctx context.Context, delivery amqp.Delivery) error {
+				defer func() { returned = true }()
+				return tt.handler(ctx, delivery)
+			}
+			err := TimeoutMiddleware(10*time.Millisecond)(handler)(context.Background(), amqp.Delivery{})
+			if !returned {
+				t.Fatalf("middleware returned before the handler")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if IsRequeue(err) != tt.wantRequeue {
+				t.Errorf("IsRequeue(%v) = %v, want %v", err, IsRequeue(err), tt.wantRequeue)
+			}
+			if IsReject(err) != tt.wantReject {
+				t.Errorf("IsReject(%v) = %v, want %v", err, IsReject(err), tt.wantReject)
+			}
+		})
+	}
+}
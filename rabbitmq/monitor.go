@@ -0,0 +1,224 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"RabbitmqConnectionDispatcher/common/log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type AlertKind string
+
+const (
+	AlertDepth       AlertKind = "depth"
+	AlertGrowth      AlertKind = "growth"
+	AlertNoConsumers AlertKind = "no_consumers"
+)
+
+// QueueThreshold configures the alerts of a monitored queue, a zero value disables a check
+type QueueThreshold struct {
+	Queue string
+
+	// Messages ready in the queue
+	MaxDepth int `mapstructure:"max_depth"`
+
+	// Growth of the depth, in messages per second, between two checks
+	MaxGrowthRate float64 `mapstructure:"max_growth_rate"`
+
+	RequireConsumers bool `mapstructure:"require_consumers"`
+}
+
+type QueueStats struct {
+	Queue      string    `json:"queue"`
+	Messages   int       `json:"messages"`
+	Consumers  int       `json:"consumers"`
+	GrowthRate float64   `json:"growth_rate"`
+	CheckedAt  time.Time `json:"checked_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type Alert struct {
+	Kind    AlertKind
+	Stats   QueueStats
+	Message string
+}
+
+//定时对配置的queue执行passive QueueInspect
+//告警只在进入告警状态时触发一次, 恢复后再次越过阈值才会重新触发
+type QueueMonitor struct {
+	interval   time.Duration
+	thresholds []QueueThreshold
+	mu         sync.RWMutex
+	stats      map[string]QueueStats
+	alerting   map[string]bool
+	onAlert    []func(Alert)
+	stopCh     chan struct{}
+}
+
+const defaultMonitorInterval = 30 * time.Second
+
+// NewQueueMonitor checks the queues every interval, 30s when interval <= 0
+func NewQueueMonitor(interval time.Duration, thresholds ...QueueThreshold) *QueueMonitor {
+	if interval <= 0 {
+		interval = defaultMonitorInterval
+	}
+	return &QueueMonitor{
+		interval:   interval,
+		thresholds: thresholds,
+		stats:      make(map[string]QueueStats),
+		alerting:   make(map[string]bool),
+	}
+}
+
+// OnAlert adds a callback, alerts are always logged
+func (m *QueueMonitor) OnAlert(fn func(Alert)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onAlert = append(m.onAlert, fn)
+}
+
+func (m *QueueMonitor) Start() {
+	m.mu.Lock()
+	if m.stopCh != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.stopCh = make(chan struct{})
+	stopCh := m.stopCh
+	m.mu.Unlock()
+	registerMonitor(m)
+
+	go func() {
+		t := time.NewTicker(m.interval)
+		defer t.Stop()
+		m.check()
+		for {
+			select {
+			case <-t.C:
+				m.check()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (m *QueueMonitor) Stop() {
+	m.mu.Lock()
+	stopCh := m.stopCh
+	m.stopCh = nil
+	m.mu.Unlock()
+	if stopCh != nil {
+		close(stopCh)
+		unregisterMonitor(m)
+	}
+}
+
+// Stats returns the result of the last check of every queue
+func (m *QueueMonitor) Stats() []QueueStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stats := make([]QueueStats, 0, len(m.stats))
+	for _, s := range m.stats {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Queue < stats[j].Queue })
+	return stats
+}
+
+func (m *QueueMonitor) check() {
+	for _, th := range m.thresholds {
+		s := QueueStats{Queue: th.Queue, CheckedAt: time.Now()}
+		err := borrowChannel(func(ch *Channel) error {
+			q, err := ch.channel.QueueInspect(th.Queue)
+			s.Messages, s.Consumers = q.Messages, q.Consumers
+			return err
+		})
+		m.mu.Lock()
+		prev, ok := m.stats[th.Queue]
+		if err != nil {
+			//保留上一次的数量, 只记录错误
+			log.Logger.Error("inspect queue ", th.Queue, " error: ", err.Error())
+			prev.Queue, prev.CheckedAt, prev.Error = th.Queue, s.CheckedAt, err.Error()
+			m.stats[th.Queue] = prev
+			m.mu.Unlock()
+			continue
+		}
+		if ok && prev.Error == "" {
+			if d := s.CheckedAt.Sub(prev.CheckedAt).Seconds(); d > 0 {
+				s.GrowthRate = float64(s.Messages-prev.Messages) / d
+			}
+		}
+		m.stats[th.Queue] = s
+		alerts := m.evaluate(th, s)
+		callbacks := m.onAlert
+		m.mu.Unlock()
+
+		for _, a := range alerts {
+			atomic.AddUint64(&metrics.QueueAlerts, 1)
+			log.Logger.Error("queue alert: ", a.Message)
+			for _, fn := range callbacks {
+				fn(a)
+			}
+		}
+	}
+}
+
+//no-lock
+func (m *QueueMonitor) evaluate(th QueueThreshold, s QueueStats) []Alert {
+	var alerts []Alert
+	fire := func(kind AlertKind, crossed bool, message string) {
+		key := th.Queue + "|" + string(kind)
+		if crossed && !m.alerting[key] {
+			alerts = append(alerts, Alert{Kind: kind, Stats: s, Message: message})
+		} else if !crossed && m.alerting[key] {
+			log.Logger.Info("queue ", th.Queue, " recovered from ", kind, " alert")
+		}
+		m.alerting[key] = crossed
+	}
+	if th.MaxDepth > 0 {
+		fire(AlertDepth, s.Messages >= th.MaxDepth,
+			fmt.Sprintf("queue %s has %d messages, threshold %d", th.Queue, s.Messages, th.MaxDepth))
+	}
+	if th.MaxGrowthRate > 0 {
+		fire(AlertGrowth, s.GrowthRate >= th.MaxGrowthRate,
+			fmt.Sprintf("queue %s grows %.2f messages/s, threshold %.2f", th.Queue, s.GrowthRate, th.MaxGrowthRate))
+	}
+	if th.RequireConsumers {
+		fire(AlertNoConsumers, s.Consumers == 0,
+			fmt.Sprintf("queue %s has no consumer", th.Queue))
+	}
+	return alerts
+}
+
+//运行中的monitor, 提供给admin接口使用
+var (
+	monitors   = make(map[*QueueMonitor]bool)
+	monitorsMu sync.RWMutex
+)
+
+func registerMonitor(m *QueueMonitor) {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	monitors[m] = true
+}
+
+func unregisterMonitor(m *QueueMonitor) {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	delete(monitors, m)
+}
+
+// QueueStatsAll returns the stats of every running QueueMonitor
+func QueueStatsAll() []QueueStats {
+	monitorsMu.RLock()
+	defer monitorsMu.RUnlock()
+	var stats []QueueStats
+	for m := range monitors {
+		stats = append(stats, m.Stats()...)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Queue < stats[j].Queue })
+	return stats
+}
@@ -0,0 +1,188 @@
+package rabbitmq
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrConsumerNotRunning = errors.New("consumer is not running")
+	ErrConsumerNotPaused  = errors.New("consumer is not paused")
+	ErrConsumerNotFound   = errors.New("consumer not found")
+)
+
+//Pause取消consumer后deliveries被关闭, channel.consumer在dispatch返回后通过wait等待Resume
+type pauseControl struct {
+	mu      sync.Mutex
+	paused  bool
+	aborted bool
+	drained chan struct{}
+	resume  chan struct{}
+}
+
+//deliveries关闭后调用, 返回true表示需要在同一个channel上重新consume
+func (p *pauseControl) wait() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	if !p.paused {
+		p.mu.Unlock()
+		return false
+	}
+	drained, resume := p.drained, p.resume
+	p.mu.Unlock()
+	//in-flight的handler已经全部返回
+	close(drained)
+	<-resume
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.aborted
+}
+
+func (p *pauseControl) isPaused() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+//no-lock 结束暂停, aborted为true时不再重新consume
+func (p *pauseControl) release(aborted bool) {
+	if !p.paused {
+		return
+	}
+	p.paused = false
+	p.aborted = aborted
+	close(p.resume)
+}
+
+// Pause cancels the consumer on the broker and waits for the in-flight handlers,
+// the channel and the topology are kept. Consume keeps blocking until Resume,
+// Shutdown or Close.
+func (c *Consumer) Pause() error {
+	c.pause.mu.Lock()
+	if c.pause.paused {
+		c.pause.mu.Unlock()
+		return nil
+	}
+	ch := c.channel
+	if c.State() != ConsumerRunning || ch == nil {
+		c.pause.mu.Unlock()
+		return ErrConsumerNotRunning
+	}
+	c.pause.paused = true
+	c.pause.aborted = false
+	c.pause.drained = make(chan struct{})
+	c.pause.resume = make(chan struct{})
+	drained, resume := c.pause.drained, c.pause.resume
+	c.pause.mu.Unlock()
+
+	if err := ch.channel.Cancel(c.session.ConsumerOptions.Tag, false); err != nil {
+		//channel已经失效, 让consume返回交给supervisor处理
+		c.pause.mu.Lock()
+		if c.pause.resume == resume {
+			c.pause.release(true)
+		}
+		c.pause.mu.Unlock()
+		return err
+	}
+	<-drained
+
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+	if c.pause.paused && c.pause.resume == resume {
+		c.setState(ConsumerPaused)
+	}
+	return nil
+}
+
+// Resume issues basic.consume again on the same channel with the same options and QoS
+func (c *Consumer) Resume() error {
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+	if !c.pause.paused {
+		return ErrConsumerNotPaused
+	}
+	c.pause.release(false)
+	c.setState(ConsumerRunning)
+	return nil
+}
+
+func (c *Consumer) abortPause() {
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+	c.pause.release(true)
+}
+
+//正在Consume的consumer, 按Tag查找, 提供给admin接口使用
+var (
+	consumers   = make(map[string]*Consumer)
+	consumersMu sync.RWMutex
+)
+
+func registerConsumer(c *Consumer) {
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	consumers[c.Tag] = c
+}
+
+func unregisterConsumer(c *Consumer) {
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	if consumers[c.Tag] == c {
+		delete(consumers, c.Tag)
+	}
+}
+
+func lookupConsumer(tag string) (*Consumer, error) {
+	consumersMu.RLock()
+	defer consumersMu.RUnlock()
+	c, ok := consumers[tag]
+	if !ok {
+		return nil, ErrConsumerNotFound
+	}
+	return c, nil
+}
+
+type ConsumerInfo struct {
+	Tag      string `json:"tag"`
+	Exchange string `json:"exchange"`
+	Queue    string `json:"queue"`
+	State    string `json:"state"`
+}
+
+func ListConsumers() []ConsumerInfo {
+	consumersMu.RLock()
+	defer consumersMu.RUnlock()
+	infos := make([]ConsumerInfo, 0, len(consumers))
+	for _, c := range consumers {
+		infos = append(infos, ConsumerInfo{
+			Tag:      c.Tag,
+			Exchange: c.session.Exchange.Name,
+			Queue:    c.session.Queue.Name,
+			State:    c.State().String(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Tag < infos[j].Tag })
+	return infos
+}
+
+func PauseConsumer(tag string) error {
+	c, err := lookupConsumer(tag)
+	if err != nil {
+		return err
+	}
+	return c.Pause()
+}
+
+func ResumeConsumer(tag string) error {
+	c, err := lookupConsumer(tag)
+	if err != nil {
+		return err
+	}
+	return c.Resume()
+}
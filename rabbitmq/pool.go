@@ -1,7 +1,6 @@
 package rabbitmq
 
 import (
-	"fmt"
 	"github.com/streadway/amqp"
 	"RabbitmqConnectionDispatcher/common/log"
 	"runtime"
@@ -17,6 +16,11 @@ type Pool struct {
 	config      *Config
 	lock        int32
 	mu          *sync.RWMutex
+
+	limiter          *rateLimiter
+	exchangeLimiters map[string]*rateLimiter
+	producerLimits   map[string]RateLimit
+	limitMu          sync.RWMutex
 }
 
 func lock(l *int32) error {
@@ -31,7 +35,7 @@ func lock(l *int32) error {
 		}
 		if cc % 5000 == 0 {
 			if cc >= 80000 {
-				return fmt.Errorf("cas acquire lock failed")
+				return ErrLockTimeout
 			}
 		}
 	}
@@ -61,6 +65,7 @@ func (p *Pool) createNewConn(mtype MQType, handlerClose bool) (*Connection, erro
 		lock:          0,
 		tag:           len(pool.connections) + 1,
 		closeHandlers: make([]func(error *amqp.Error), 0),
+		topology:      newTopologyCache(),
 	}
 	if handlerClose {
 		c.handleError()
@@ -80,7 +85,7 @@ func (p *Pool) createNewConn(mtype MQType, handlerClose bool) (*Connection, erro
 //生产者和消费者加锁情况不一样 所以这里面不进行加锁
 func (pool *Pool) chooseIdleConnection(mtype MQType) *Connection {
 	for _, conns := range pool.connections {
-		if conns.mtype == mtype {
+		if conns.mtype == mtype && conns.connection != nil && !conns.connection.IsClosed() {
 			if conns.hasFreeConnection(mtype) {
 				return conns
 			}
@@ -134,6 +139,15 @@ func InitPool(config *Config) {
 		config: config,
 		lock: 0,
 		mu: new(sync.RWMutex),
+		limiter: newRateLimiter(config.PublishRateLimit, RateLimitScopeGlobal, ""),
+		exchangeLimiters: make(map[string]*rateLimiter),
+		producerLimits: make(map[string]RateLimit),
+	}
+	for _, rl := range config.ProducerRateLimits {
+		pool.producerLimits[rl.Producer] = rl
+	}
+	for _, rl := range config.ExchangeRateLimits {
+		SetExchangeRateLimit(rl)
 	}
 	cs, err := pool.createNewConn(MQTypeProducer, true)
 	if err != nil {
@@ -4,12 +4,26 @@ import (
 	"fmt"
 	"github.com/streadway/amqp"
 	"log"
+	"time"
 )
 
 type Producer struct {
 	session Session
 	channel *Channel
 	tag     *string //多线程中用到
+	codec   Codec
+	retry   *RetryPolicy
+	limiter *rateLimiter
+
+	compressor        Compressor
+	compressThreshold int
+	chunkSize         int
+
+	delay *delayTiers
+	// Extra topology declared after the exchange, e.g. the queue of a delay tier
+	topology func(ch *Channel) error
+	// Publish to an existing exchange without declaring it
+	skipDeclare bool
 }
 
 //non-thread-safe
@@ -19,6 +33,7 @@ func NewProducer(e Exchange, bo BindingOptions) *Producer {
 			Exchange: e,
 			BindingOptions: bo,
 		},
+		delay: newDelayTiers(),
 	}
 }
 
@@ -30,30 +45,149 @@ func NewSafeProducer(e Exchange, bo BindingOptions, unique string) *Producer {
 			BindingOptions: bo,
 		},
 		tag: &unique,
+		delay: newDelayTiers(),
 	}
 }
 
 func (p *Producer) Publish(body []byte) (*Connection, error) {
+	return p.send(p.session.BindingOptions.RoutingKey, amqp.Publishing{
+		ContentType: "text/plain",
+		Body: body,
+	})
+}
+
+//使用SetCodec设置的codec编码v, 默认为JSONCodec
+func (p *Producer) PublishValue(v interface{}) (*Connection, error) {
+	codec := p.codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+	body, err := codec.Marshal(v)
+	if err != nil { return nil, err }
+	return p.send(p.session.BindingOptions.RoutingKey, amqp.Publishing{
+		ContentType: codec.ContentType(),
+		Body: body,
+	})
+}
+
+func (p *Producer) SetCodec(codec Codec) {
+	p.codec = codec
+}
+
+//nil表示不重试
+//同步的Producer不开启confirm模式, 只重试连接和channel等传输错误,
+//broker nack和confirm超时的重试只在AsyncProducer中发生
+func (p *Producer) SetRetryPolicy(rp *RetryPolicy) {
+	p.retry = rp
+}
+
+//限制这个producer的发送速率, rl.Rate <= 0表示不限制
+func (p *Producer) SetRateLimit(rl RateLimit) {
+	p.limiter = newRateLimiter(rl, RateLimitScopeProducer, p.channelKey())
+}
+
+//使用Config.ProducerRateLimits中名为name的限制, 即application.yml的rate_limit.producers
+//没有配置时返回false, producer保持不限制
+func (p *Producer) UseRateLimit(name string) bool {
+	rl, ok := producerRateLimit(name)
+	if !ok {
+		return false
+	}
+	p.limiter = newRateLimiter(rl, RateLimitScopeProducer, name)
+	return true
+}
+
+//body大于等于threshold字节时压缩并设置content-encoding, nil表示不压缩
+func (p *Producer) SetCompression(c Compressor, threshold int) {
+	p.compressor = c
+	p.compressThreshold = threshold
+}
+
+//body超过size字节时拆分成多条消息发送, consumer需要开启SetReassembly, 0表示不拆分
+func (p *Producer) SetChunkSize(size int) {
+	p.chunkSize = size
+}
+
+//没有message id的消息生成一个, quarantine和dedupe按message id识别消息
+func (p *Producer) send(routingKey string, msg amqp.Publishing) (*Connection, error) {
+	if msg.MessageId == "" {
+		msg.MessageId = newMessageID()
+	}
+	msg, err := compressPublishing(p.compressor, p.compressThreshold, msg)
+	if err != nil {
+		return nil, err
+	}
+	if p.chunkSize > 0 && len(msg.Body) > p.chunkSize {
+		return p.sendChunks(routingKey, msg)
+	}
+	return p.sendMessage(routingKey, msg)
+}
+
+//除最后一个分片外, 每个分片发送后都把channel放回pool, 最后一个由调用者Shutdown
+func (p *Producer) sendChunks(routingKey string, msg amqp.Publishing) (*Connection, error) {
+	chunks := splitPublishing(msg, p.chunkSize)
+	for i, chunk := range chunks {
+		conn, err := p.sendMessage(routingKey, chunk)
+		if err != nil || i == len(chunks)-1 {
+			return conn, err
+		}
+		if err := conn.shutdownIfNeeded(p.channelKey(), MQTypeProducer, nil); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (p *Producer) sendMessage(routingKey string, msg amqp.Publishing) (*Connection, error) {
+	if err := p.limiter.wait(); err != nil {
+		return nil, err
+	}
+	if err := pool.allowPublish(p.session.Exchange.Name); err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		conn, ch, err := p.sendOnce(routingKey, msg)
+		if !p.retry.shouldRetry(attempt, err) {
+			countPublish(err)
+			return conn, err
+		}
+		//失败的channel不再放回pool, 下次重试会重新选择connection和channel
+		if ch != nil {
+			conn.discardChannel(ch, MQTypeProducer)
+		}
+		log.Printf("publish to %s failed: %s, retry %d times", p.session.Exchange.Name, err.Error(), attempt)
+		time.Sleep(p.retry.backoff(attempt))
+	}
+}
+
+func (p *Producer) sendOnce(routingKey string, msg amqp.Publishing) (*Connection, *Channel, error) {
+	conn, ch, err := p.acquire()
+	if err != nil { return nil, nil, err }
+	return conn, ch, conn.doPublish(ch, p.session, routingKey, msg)
+}
+
+//取出一个已经声明过exchange的channel, 此时channel还未放入used pool
+func (p *Producer) acquire() (*Connection, *Channel, error) {
 	pool.mu.RLock()
 	conn := pool.chooseIdleConnection(MQTypeProducer)
 	pool.mu.RUnlock()
 	if conn == nil {
 		if pool.reachMaxConnection() {
-			return nil, fmt.Errorf("Maximum number of connections reached")
+			return nil, nil, fmt.Errorf("Maximum number of connections reached")
 		}
 		//has no free connection  create new connection
 		conns, err := pool.createNewConn(MQTypeProducer, true)
-		if err != nil { return nil, err }
+		if err != nil { return nil, nil, err }
 		//create new channel
 		newCh, err := conns.createNewProducerChannel()
-		if err != nil { return nil, err }
+		if err != nil { return nil, nil, err }
 
 		err = p.bind(newCh)
 		if err != nil {
 			newCh.close(MQTypeProducer, nil)
-			return nil, err
+			return nil, nil, err
 		}
-		return conns, conns.doPublish(newCh, p.session, body)
+		return conns, newCh, nil
 
 	} else {
 		//has free connection
@@ -65,21 +199,21 @@ func (p *Producer) Publish(body []byte) (*Connection, error) {
 					log.Printf("%s", err1.Error())
 					ch.close(MQTypeProducer, nil)
 				}
-				return nil, err
+				return nil, nil, err
 			}
-			return conn, conn.doPublish(ch, p.session, body)
+			return conn, ch, nil
 		}
 
 		//have idle connection but no idle channel, create new channel
 		newCh, err := conn.createNewProducerChannel()
-		if err != nil { return nil, err }
+		if err != nil { return nil, nil, err }
 
 		err = p.bind(newCh)//bind exchange
 		if err != nil {
 			newCh.close(MQTypeProducer, nil)//do not put into idle channel pool
-			return nil, err
+			return nil, nil, err
 		}
-		return conn, conn.doPublish(newCh, p.session, body)
+		return conn, newCh, nil
 	}
 }
 
@@ -92,20 +226,47 @@ func (p *Producer) Shutdown(conn *Connection) error {
 	return conn.shutdownIfNeeded(p.channelKey(), MQTypeProducer, nil)
 }
 
+//通过pool发送一条消息并立即把channel放回pool, 不声明exchange
+func publishOnce(exchange string, routingKey string, msg amqp.Publishing) error {
+	p := NewSafeProducer(Exchange{Name: exchange}, BindingOptions{RoutingKey: routingKey}, newMessageID())
+	p.skipDeclare = true
+	conn, err := p.send(routingKey, msg)
+	if conn != nil {
+		if err1 := p.Shutdown(conn); err1 != nil {
+			log.Printf("%s", err1.Error())
+		}
+	}
+	return err
+}
+
+//声明destination和source exchange并绑定, 用于在代码中构建fan-in topology
+func BindExchange(destination Exchange, eb ExchangeBinding) error {
+	p := NewSafeProducer(destination, BindingOptions{}, "bind"+newMessageID())
+	p.topology = func(ch *Channel) error {
+		return ch.bindExchange(destination, eb)
+	}
+	conn, ch, err := p.acquire()
+	if err != nil { return err }
+	//只声明不发送, 直接放回idle pool
+	ch.clean(MQTypeProducer, nil)
+	if err := conn.occupied(ch, false, true); err != nil {
+		ch.close(MQTypeProducer, nil)
+	}
+	return nil
+}
+
 func (p *Producer) bind(ch *Channel) error {
 	p.channel = ch
-	e := p.session.Exchange
 	// declaring Exchange
-	if err1 := p.channel.channel.ExchangeDeclare(
-		e.Name,       // name of the exchange
-		e.Type,       // type
-		e.Durable,    // durable
-		e.AutoDelete, // delete when complete
-		e.Internal,   // internal
-		e.NoWait,     // noWait
-		e.Args,       // arguments
-	); err1 != nil {
-		return err1
+	if !p.skipDeclare {
+		if err := p.channel.declareExchange(p.session.Exchange); err != nil {
+			return err
+		}
+	}
+	if p.topology != nil {
+		if err := p.topology(p.channel); err != nil {
+			return err
+		}
 	}
 
 	p.channel.tag = p.channelKey()
@@ -0,0 +1,314 @@
+package rabbitmq
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/cache/lru"
+	"RabbitmqConnectionDispatcher/common/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HeaderOriginalExchange   = "x-original-exchange"
+	HeaderOriginalRoutingKey = "x-original-routing-key"
+	HeaderQuarantinedAt      = "x-quarantined-at"
+	HeaderDeliveries         = "x-deliveries"
+	// Set by quorum queues on redelivered messages, the number of previous deliveries
+	HeaderDeliveryCount = "x-delivery-count"
+
+	quarantineCacheSize    = 10000
+	quarantineCountExpired = time.Hour
+)
+
+var ErrMessageNotFound = fmt.Errorf("message not found")
+
+//已开启隔离的queue, 提供给admin接口使用
+var (
+	quarantines  = make(map[string]bool)
+	quarantineMu sync.RWMutex
+)
+
+func quarantineQueueName(queue string) string {
+	return queue + ".quarantine"
+}
+
+func registerQuarantine(queue string) {
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+	quarantines[queue] = true
+}
+
+func isQuarantined(queue string) bool {
+	quarantineMu.RLock()
+	defer quarantineMu.RUnlock()
+	return quarantines[queue]
+}
+
+// DeliveryCounter counts the deliveries of a message for quarantine. The
+// in-memory counter of SetQuarantine is lost when the process crashes, use a
+// counter that survives restarts (e.g. redisstore.DeliveryCounter) to catch
+// messages that crash the consumer, quorum queues do not need one.
+type DeliveryCounter interface {
+	// Incr returns the number of deliveries of id including this one
+	Incr(id string, ttl time.Duration) (int, error)
+	Forget(id string) error
+}
+
+type lruDeliveryCounter struct {
+	mu     sync.Mutex
+	counts *lru.SafeCache
+}
+
+func NewLRUDeliveryCounter(size int) (DeliveryCounter, error) {
+	counts, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &lruDeliveryCounter{counts: counts}, nil
+}
+
+func (c *lruDeliveryCounter) Incr(id string, ttl time.Duration) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 1
+	if v, ok := c.counts.Get(id); ok {
+		n = v.(int) + 1
+	}
+	c.counts.AddWithExpired(id, n, ttlSeconds(ttl))
+	return n, nil
+}
+
+func (c *lruDeliveryCounter) Forget(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts.Remove(id)
+	return nil
+}
+
+//统计投递次数, 超过threshold的消息转移到隔离queue
+type quarantine struct {
+	threshold int
+	queue     Queue
+	autoAck   bool
+	counter   DeliveryCounter
+}
+
+//同一条消息投递超过threshold次(handler失败重新入队或者处理时崩溃)后, 消息被转移到<queue>.quarantine
+//投递次数保存在进程内存中, 进程崩溃后重新计数, 需要持久化时使用SetQuarantineWithCounter
+func (c *Consumer) SetQuarantine(threshold int) {
+	counter, err := NewLRUDeliveryCounter(quarantineCacheSize)
+	if err != nil {
+		log.Logger.Error(err.Error())
+		return
+	}
+	c.SetQuarantineWithCounter(threshold, counter)
+}
+
+func (c *Consumer) SetQuarantineWithCounter(threshold int, counter DeliveryCounter) {
+	c.quarantine = &quarantine{
+		threshold: threshold,
+		queue:     c.session.Queue,
+		autoAck:   c.session.ConsumerOptions.AutoAck,
+		counter:   counter,
+	}
+	registerQuarantine(c.session.Queue.Name)
+}
+
+func (q *quarantine) declare(ch *Channel) error {
+	return ch.declareQueue(Queue{
+		Name:    quarantineQueueName(q.queue.Name),
+		Durable: q.queue.Durable,
+	})
+}
+
+//没有message id的消息(例如其他客户端发送的)使用body的hash
+func quarantineKey(delivery amqp.Delivery) string {
+	if delivery.MessageId != "" {
+		return delivery.MessageId
+	}
+	sum := sha1.Sum(delivery.Body)
+	return "sha1:" + hex.EncodeToString(sum[:])
+}
+
+//quorum queue的投递次数由broker记录, 其他queue使用counter
+//第一次投递(Redelivered为false)时重新计数, 避免相同key的旧计数
+func (q *quarantine) count(delivery amqp.Delivery, key string) (int, error) {
+	if n, ok := headerInt(delivery.Headers, HeaderDeliveryCount); ok {
+		return int(n) + 1, nil
+	}
+	if !delivery.Redelivered {
+		if err := q.counter.Forget(key); err != nil {
+			return 0, err
+		}
+	}
+	return q.counter.Incr(key, quarantineCountExpired)
+}
+
+func (q *quarantine) wrap(handler func(delivery amqp.Delivery)) func(delivery amqp.Delivery) {
+	return func(delivery amqp.Delivery) {
+		if q.autoAck {
+			handler(delivery)
+			return
+		}
+		key := quarantineKey(delivery)
+		n, err := q.count(delivery, key)
+		if err != nil {
+			log.Logger.Error("count deliveries of message ", key, " error: ", err.Error())
+			handler(delivery)
+			return
+		}
+		if n <= q.threshold {
+			handler(delivery)
+			return
+		}
+		if err := q.park(delivery, n); err != nil {
+			log.Logger.Error("quarantine message ", key, " error: ", err.Error())
+			handler(delivery)
+			return
+		}
+		log.Logger.Info("message ", key, " delivered ", n, " times, moved to ", quarantineQueueName(q.queue.Name))
+		if err := q.counter.Forget(key); err != nil {
+			log.Logger.Error(err.Error())
+		}
+		atomic.AddUint64(&metrics.Quarantined, 1)
+		if err := delivery.Ack(false); err != nil {
+			log.Logger.Error(err.Error())
+		}
+	}
+}
+
+func (q *quarantine) park(delivery amqp.Delivery, deliveries int) error {
+	msg := deliveryToPublishing(delivery)
+	msg.Headers[HeaderOriginalExchange] = delivery.Exchange
+	msg.Headers[HeaderOriginalRoutingKey] = delivery.RoutingKey
+	msg.Headers[HeaderQuarantinedAt] = time.Now()
+	msg.Headers[HeaderDeliveries] = int32(deliveries)
+	return publishOnce("", quarantineQueueName(q.queue.Name), msg)
+}
+
+// QuarantinedMessage is a message in a quarantine queue
+type QuarantinedMessage struct {
+	MessageId     string     `json:"message_id"`
+	Exchange      string     `json:"exchange"`
+	RoutingKey    string     `json:"routing_key"`
+	ContentType   string     `json:"content_type"`
+	Deliveries    int64      `json:"deliveries"`
+	QuarantinedAt time.Time  `json:"quarantined_at"`
+	Headers       amqp.Table `json:"headers"`
+	Body          string     `json:"body"`
+}
+
+func newQuarantinedMessage(d amqp.Delivery) QuarantinedMessage {
+	m := QuarantinedMessage{
+		MessageId:   d.MessageId,
+		ContentType: d.ContentType,
+		Headers:     d.Headers,
+		Body:        string(d.Body),
+	}
+	m.Exchange, _ = d.Headers[HeaderOriginalExchange].(string)
+	m.RoutingKey, _ = d.Headers[HeaderOriginalRoutingKey].(string)
+	m.QuarantinedAt, _ = d.Headers[HeaderQuarantinedAt].(time.Time)
+	m.Deliveries, _ = headerInt(d.Headers, HeaderDeliveries)
+	return m
+}
+
+//在一个借用的channel上取出最多limit条消息, fn返回后未ack的消息全部重新入队
+func inspectQuarantine(queue string, limit int, fn func(ch *amqp.Channel, deliveries []amqp.Delivery) error) error {
+	if !isQuarantined(queue) {
+		return fmt.Errorf("queue %s has no quarantine", queue)
+	}
+	p := NewSafeProducer(Exchange{}, BindingOptions{}, "quarantine"+newMessageID())
+	p.skipDeclare = true
+	conn, ch, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	if err := conn.occupied(ch, true, true); err != nil {
+		ch.close(MQTypeProducer, nil)
+		return err
+	}
+
+	var deliveries []amqp.Delivery
+	for limit <= 0 || len(deliveries) < limit {
+		d, ok, err := ch.channel.Get(quarantineQueueName(queue), false)
+		if err != nil {
+			conn.discardChannel(ch, MQTypeProducer)
+			return err
+		}
+		if !ok {
+			break
+		}
+		deliveries = append(deliveries, d)
+	}
+	err = fn(ch.channel, deliveries)
+	if len(deliveries) > 0 {
+		//delivery tag为0且multiple为true表示所有未确认的消息
+		if err1 := ch.channel.Nack(0, true, true); err1 != nil {
+			conn.discardChannel(ch, MQTypeProducer)
+			return err1
+		}
+	}
+	if err1 := p.Shutdown(conn); err1 != nil {
+		log.Logger.Error(err1.Error())
+	}
+	return err
+}
+
+func ListQuarantined(queue string, limit int) ([]QuarantinedMessage, error) {
+	var messages []QuarantinedMessage
+	err := inspectQuarantine(queue, limit, func(ch *amqp.Channel, deliveries []amqp.Delivery) error {
+		for _, d := range deliveries {
+			messages = append(messages, newQuarantinedMessage(d))
+		}
+		return nil
+	})
+	return messages, err
+}
+
+//按message id查找, 最多查看整个queue
+func findQuarantined(queue, id string, fn func(ch *amqp.Channel, d amqp.Delivery) error) error {
+	return inspectQuarantine(queue, 0, func(ch *amqp.Channel, deliveries []amqp.Delivery) error {
+		for _, d := range deliveries {
+			if d.MessageId == id {
+				return fn(ch, d)
+			}
+		}
+		return ErrMessageNotFound
+	})
+}
+
+func GetQuarantined(queue, id string) (QuarantinedMessage, error) {
+	var m QuarantinedMessage
+	err := findQuarantined(queue, id, func(ch *amqp.Channel, d amqp.Delivery) error {
+		m = newQuarantinedMessage(d)
+		return nil
+	})
+	return m, err
+}
+
+func DeleteQuarantined(queue, id string) error {
+	return findQuarantined(queue, id, func(ch *amqp.Channel, d amqp.Delivery) error {
+		return ch.Ack(d.DeliveryTag, false)
+	})
+}
+
+// ReplayQuarantined publishes the message back to its original exchange and routing key
+func ReplayQuarantined(queue, id string) error {
+	return findQuarantined(queue, id, func(ch *amqp.Channel, d amqp.Delivery) error {
+		msg := deliveryToPublishing(d)
+		exchange, _ := d.Headers[HeaderOriginalExchange].(string)
+		routingKey, _ := d.Headers[HeaderOriginalRoutingKey].(string)
+		delete(msg.Headers, HeaderOriginalExchange)
+		delete(msg.Headers, HeaderOriginalRoutingKey)
+		delete(msg.Headers, HeaderQuarantinedAt)
+		delete(msg.Headers, HeaderDeliveries)
+		if err := publishOnce(exchange, routingKey, msg); err != nil {
+			return err
+		}
+		return ch.Ack(d.DeliveryTag, false)
+	})
+}
@@ -0,0 +1,75 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestQuarantineCount(t *testing.T) {
+	tests := []struct {
+		name       string
+		deliveries []amqp.Delivery
+		want       []int
+	}{
+		{
+			name: "redelivered",
+			deliveries: []amqp.Delivery{
+				{MessageId: "a"},
+				{MessageId: "a", Redelivered: true},
+				{MessageId: "a", Redelivered: true},
+			},
+			want: []int{1, 2, 3},
+		},
+		{
+			name: "first delivery resets the count",
+			deliveries: []amqp.Delivery{
+				{MessageId: "a"},
+				{MessageId: "a", Redelivered: true},
+				{MessageId: "a"},
+			},
+			want: []int{1, 2, 1},
+		},
+		{
+			name: "redelivered after a restart",
+			deliveries: []amqp.Delivery{
+				{MessageId: "a", Redelivered: true},
+				{MessageId: "a", Redelivered: true},
+			},
+			want: []int{1, 2},
+		},
+		{
+			name: "quorum delivery count",
+			deliveries: []amqp.Delivery{
+				{MessageId: "a", Redelivered: true, Headers: amqp.Table{HeaderDeliveryCount: int64(4)}},
+			},
+			want: []int{5},
+		},
+		{
+			name: "body hash without message id",
+			deliveries: []amqp.Delivery{
+				{Body: []byte("poison")},
+				{Body: []byte("poison"), Redelivered: true},
+				{Body: []byte("other"), Redelivered: true},
+			},
+			want: []int{1, 2, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, err := NewLRUDeliveryCounter(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := &quarantine{threshold: 3, counter: counter}
+			for i, d := range tt.deliveries {
+				n, err := q.count(d, quarantineKey(d))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != tt.want[i] {
+					t.Errorf("delivery %d count = %d, want %d", i, n, tt.want[i])
+				}
+			}
+		})
+	}
+}
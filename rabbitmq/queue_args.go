@@ -0,0 +1,215 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+type QueueType string
+
+const (
+	QueueTypeClassic QueueType = "classic"
+	QueueTypeQuorum  QueueType = "quorum"
+)
+
+// Overflow is the behaviour of a queue when max-length or max-length-bytes is reached
+type Overflow string
+
+const (
+	OverflowDropHead         Overflow = "drop-head"
+	OverflowRejectPublish    Overflow = "reject-publish"
+	OverflowRejectPublishDLX Overflow = "reject-publish-dlx"
+)
+
+const maxPriorityLimit = 255
+
+//non-thread-safe
+//构建Queue.Args, 每个setter只记录参数, Build时统一校验
+//
+//	args, err := NewQueueArgs().Quorum().MaxLength(10000).Overflow(OverflowRejectPublish).Build()
+type QueueArgs struct {
+	queueType            QueueType
+	lazy                 bool
+	maxLength            *int64
+	maxLengthBytes       *int64
+	overflow             Overflow
+	messageTTL           *time.Duration
+	expires              *time.Duration
+	deadLetterExchange   *string
+	deadLetterRoutingKey *string
+	singleActiveConsumer bool
+	maxPriority          *int
+}
+
+func NewQueueArgs() *QueueArgs {
+	return &QueueArgs{}
+}
+
+func (a *QueueArgs) Classic() *QueueArgs {
+	a.queueType = QueueTypeClassic
+	return a
+}
+
+// Quorum queues must be durable, not exclusive and not auto delete
+func (a *QueueArgs) Quorum() *QueueArgs {
+	a.queueType = QueueTypeQuorum
+	return a
+}
+
+// Lazy keeps messages on disk, only for classic queues
+func (a *QueueArgs) Lazy() *QueueArgs {
+	a.lazy = true
+	return a
+}
+
+func (a *QueueArgs) MaxLength(n int64) *QueueArgs {
+	a.maxLength = &n
+	return a
+}
+
+func (a *QueueArgs) MaxLengthBytes(n int64) *QueueArgs {
+	a.maxLengthBytes = &n
+	return a
+}
+
+func (a *QueueArgs) Overflow(o Overflow) *QueueArgs {
+	a.overflow = o
+	return a
+}
+
+// MessageTTL is rounded down to milliseconds
+func (a *QueueArgs) MessageTTL(d time.Duration) *QueueArgs {
+	a.messageTTL = &d
+	return a
+}
+
+// Expires deletes the queue after it has been unused for d
+func (a *QueueArgs) Expires(d time.Duration) *QueueArgs {
+	a.expires = &d
+	return a
+}
+
+// DeadLetterExchange may be "", the default exchange
+func (a *QueueArgs) DeadLetterExchange(exchange string) *QueueArgs {
+	a.deadLetterExchange = &exchange
+	return a
+}
+
+func (a *QueueArgs) DeadLetterRoutingKey(routingKey string) *QueueArgs {
+	a.deadLetterRoutingKey = &routingKey
+	return a
+}
+
+func (a *QueueArgs) SingleActiveConsumer() *QueueArgs {
+	a.singleActiveConsumer = true
+	return a
+}
+
+// MaxPriority is between 1 and 255, only for classic queues
+func (a *QueueArgs) MaxPriority(n int) *QueueArgs {
+	a.maxPriority = &n
+	return a
+}
+
+func (a *QueueArgs) validate() error {
+	switch a.queueType {
+	case "", QueueTypeClassic, QueueTypeQuorum:
+	default:
+		return fmt.Errorf("queue args: unknown queue type %s", a.queueType)
+	}
+	if a.maxLength != nil && *a.maxLength < 0 {
+		return fmt.Errorf("queue args: max length %d is negative", *a.maxLength)
+	}
+	if a.maxLengthBytes != nil && *a.maxLengthBytes < 0 {
+		return fmt.Errorf("queue args: max length bytes %d is negative", *a.maxLengthBytes)
+	}
+	switch a.overflow {
+	case "":
+	case OverflowDropHead, OverflowRejectPublish, OverflowRejectPublishDLX:
+		if a.maxLength == nil && a.maxLengthBytes == nil {
+			return fmt.Errorf("queue args: overflow %s without max length", a.overflow)
+		}
+	default:
+		return fmt.Errorf("queue args: unknown overflow %s", a.overflow)
+	}
+	if a.messageTTL != nil && *a.messageTTL < 0 {
+		return fmt.Errorf("queue args: message ttl %s is negative", *a.messageTTL)
+	}
+	if a.expires != nil && *a.expires < time.Millisecond {
+		return fmt.Errorf("queue args: expires %s is less than 1ms", *a.expires)
+	}
+	if a.deadLetterRoutingKey != nil && a.deadLetterExchange == nil {
+		return fmt.Errorf("queue args: dead letter routing key without dead letter exchange")
+	}
+	if a.maxPriority != nil && (*a.maxPriority < 1 || *a.maxPriority > maxPriorityLimit) {
+		return fmt.Errorf("queue args: max priority %d is out of range 1-%d", *a.maxPriority, maxPriorityLimit)
+	}
+	if a.queueType == QueueTypeQuorum {
+		if a.lazy {
+			return fmt.Errorf("queue args: quorum queues do not support lazy mode")
+		}
+		if a.maxPriority != nil {
+			return fmt.Errorf("queue args: quorum queues do not support priorities")
+		}
+		if a.overflow == OverflowRejectPublishDLX {
+			return fmt.Errorf("queue args: quorum queues do not support overflow %s", a.overflow)
+		}
+	}
+	return nil
+}
+
+func (a *QueueArgs) Build() (amqp.Table, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	args := amqp.Table{}
+	if a.queueType != "" {
+		args["x-queue-type"] = string(a.queueType)
+	}
+	if a.lazy {
+		args["x-queue-mode"] = "lazy"
+	}
+	if a.maxLength != nil {
+		args["x-max-length"] = *a.maxLength
+	}
+	if a.maxLengthBytes != nil {
+		args["x-max-length-bytes"] = *a.maxLengthBytes
+	}
+	if a.overflow != "" {
+		args["x-overflow"] = string(a.overflow)
+	}
+	if a.messageTTL != nil {
+		args["x-message-ttl"] = int64(*a.messageTTL / time.Millisecond)
+	}
+	if a.expires != nil {
+		args["x-expires"] = int64(*a.expires / time.Millisecond)
+	}
+	if a.deadLetterExchange != nil {
+		args["x-dead-letter-exchange"] = *a.deadLetterExchange
+	}
+	if a.deadLetterRoutingKey != nil {
+		args["x-dead-letter-routing-key"] = *a.deadLetterRoutingKey
+	}
+	if a.singleActiveConsumer {
+		args["x-single-active-consumer"] = true
+	}
+	if a.maxPriority != nil {
+		args["x-max-priority"] = int64(*a.maxPriority)
+	}
+	return args, nil
+}
+
+// WithArgs returns a copy of q using the built arguments, the queue flags are
+// checked against the queue type
+func (q Queue) WithArgs(a *QueueArgs) (Queue, error) {
+	args, err := a.Build()
+	if err != nil {
+		return q, err
+	}
+	if a.queueType == QueueTypeQuorum && (!q.Durable || q.Exclusive || q.AutoDelete) {
+		return q, fmt.Errorf("queue args: quorum queue %s must be durable, not exclusive and not auto delete", q.Name)
+	}
+	q.Args = args
+	return q, nil
+}
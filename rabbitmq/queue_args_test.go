@@ -0,0 +1,132 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueueArgsBuild(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    *QueueArgs
+		want    amqp.Table
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			args: NewQueueArgs(),
+			want: amqp.Table{},
+		},
+		{
+			name: "classic lazy with limits",
+			args: NewQueueArgs().Classic().Lazy().MaxLength(100).MaxLengthBytes(1024).Overflow(OverflowRejectPublishDLX),
+			want: amqp.Table{
+				"x-queue-type":       "classic",
+				"x-queue-mode":       "lazy",
+				"x-max-length":       int64(100),
+				"x-max-length-bytes": int64(1024),
+				"x-overflow":         "reject-publish-dlx",
+			},
+		},
+		{
+			name: "quorum with dead letter",
+			args: NewQueueArgs().Quorum().MaxLength(10).Overflow(OverflowRejectPublish).DeadLetterExchange("dlx").DeadLetterRoutingKey("dead"),
+			want: amqp.Table{
+				"x-queue-type":              "quorum",
+				"x-max-length":              int64(10),
+				"x-overflow":                "reject-publish",
+				"x-dead-letter-exchange":    "dlx",
+				"x-dead-letter-routing-key": "dead",
+			},
+		},
+		{
+			name: "durations in milliseconds",
+			args: NewQueueArgs().MessageTTL(1500 * time.Millisecond).Expires(time.Minute),
+			want: amqp.Table{
+				"x-message-ttl": int64(1500),
+				"x-expires":     int64(60000),
+			},
+		},
+		{
+			name: "zero message ttl",
+			args: NewQueueArgs().MessageTTL(0),
+			want: amqp.Table{"x-message-ttl": int64(0)},
+		},
+		{
+			name: "single active consumer and priority",
+			args: NewQueueArgs().SingleActiveConsumer().MaxPriority(maxPriorityLimit),
+			want: amqp.Table{
+				"x-single-active-consumer": true,
+				"x-max-priority":           int64(255),
+			},
+		},
+		{name: "unknown queue type", args: &QueueArgs{queueType: "stream"}, wantErr: true},
+		{name: "unknown overflow", args: NewQueueArgs().MaxLength(1).Overflow("drop-tail"), wantErr: true},
+		{name: "negative max length", args: NewQueueArgs().MaxLength(-1), wantErr: true},
+		{name: "negative max length bytes", args: NewQueueArgs().MaxLengthBytes(-1), wantErr: true},
+		{name: "overflow without max length", args: NewQueueArgs().Overflow(OverflowDropHead), wantErr: true},
+		{name: "negative message ttl", args: NewQueueArgs().MessageTTL(-time.Second), wantErr: true},
+		{name: "expires less than 1ms", args: NewQueueArgs().Expires(time.Microsecond), wantErr: true},
+		{name: "dead letter routing key without exchange", args: NewQueueArgs().DeadLetterRoutingKey("dead"), wantErr: true},
+		{name: "priority 0", args: NewQueueArgs().MaxPriority(0), wantErr: true},
+		{name: "priority 256", args: NewQueueArgs().MaxPriority(256), wantErr: true},
+		{name: "quorum lazy", args: NewQueueArgs().Quorum().Lazy(), wantErr: true},
+		{name: "quorum priority", args: NewQueueArgs().Quorum().MaxPriority(10), wantErr: true},
+		{name: "quorum reject publish dlx", args: NewQueueArgs().Quorum().MaxLength(1).Overflow(OverflowRejectPublishDLX), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.args.Build()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Build() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Build() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueWithArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		queue   Queue
+		args    *QueueArgs
+		wantErr bool
+	}{
+		{name: "durable quorum", queue: Queue{Name: "q", Durable: true}, args: NewQueueArgs().Quorum()},
+		{name: "transient quorum", queue: Queue{Name: "q"}, args: NewQueueArgs().Quorum(), wantErr: true},
+		{name: "exclusive quorum", queue: Queue{Name: "q", Durable: true, Exclusive: true}, args: NewQueueArgs().Quorum(), wantErr: true},
+		{name: "auto delete quorum", queue: Queue{Name: "q", Durable: true, AutoDelete: true}, args: NewQueueArgs().Quorum(), wantErr: true},
+		{name: "exclusive classic", queue: Queue{Name: "q", Exclusive: true, AutoDelete: true}, args: NewQueueArgs().Classic()},
+		{name: "invalid args", queue: Queue{Name: "q", Durable: true}, args: NewQueueArgs().MaxPriority(0), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := tt.queue.WithArgs(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("WithArgs() = %v, want error", q)
+				}
+				if q.Args != nil {
+					t.Errorf("Args = %v, want unchanged", q.Args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WithArgs() error: %v", err)
+			}
+			if q.Args["x-queue-type"] != string(tt.args.queueType) {
+				t.Errorf("x-queue-type = %v, want %s", q.Args["x-queue-type"], tt.args.queueType)
+			}
+		})
+	}
+}
@@ -29,6 +29,12 @@ type Config struct {
 
 	MaxProducerChannelPerConn  int
 	MaxConcusmerChannelPerConn int
+
+	// Publish rate limit of the whole pool
+	PublishRateLimit   RateLimit
+	ExchangeRateLimits []RateLimit
+	// Looked up by name with Producer.UseRateLimit
+	ProducerRateLimits []RateLimit
 }
 
 type Session struct {
@@ -41,6 +47,13 @@ type Session struct {
 	// Binding options for current exchange to queue binding
 	BindingOptions BindingOptions
 
+	// Additional bindings of the queue to the exchange, e.g. more topic patterns.
+	// When set, an empty BindingOptions is not bound.
+	Bindings []BindingOptions
+
+	// Bindings of other exchanges into the exchange, used for fan-in topologies
+	ExchangeBindings []ExchangeBinding
+
 	// Consumer options for a queue or exchange
 	ConsumerOptions ConsumerOptions
 }
@@ -134,6 +147,35 @@ type BindingOptions struct {
 	Args amqp.Table
 }
 
+const (
+	HeadersMatchAll = "all"
+	HeadersMatchAny = "any"
+)
+
+// HeadersBinding builds the binding of a headers exchange, match is
+// HeadersMatchAll or HeadersMatchAny
+func HeadersBinding(match string, headers amqp.Table) (BindingOptions, error) {
+	if match != HeadersMatchAll && match != HeadersMatchAny {
+		return BindingOptions{}, fmt.Errorf("x-match must be %s or %s, got %q", HeadersMatchAll, HeadersMatchAny, match)
+	}
+	args := make(amqp.Table, len(headers)+1)
+	for k, v := range headers {
+		args[k] = v
+	}
+	args["x-match"] = match
+	return BindingOptions{Args: args}, args.Validate()
+}
+
+type ExchangeBinding struct {
+	// Source exchange, it is declared before binding
+	Source Exchange
+
+	// Check BindingOptions comments
+	RoutingKey string
+	NoWait     bool
+	Args       amqp.Table
+}
+
 func dial(config *Config) (*amqp.Connection, error) {
 	conf := amqp.URI{
 		Scheme:   "amqp",
@@ -0,0 +1,40 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestHeadersBinding(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   string
+		headers amqp.Table
+		wantErr bool
+	}{
+		{name: "all", match: HeadersMatchAll, headers: amqp.Table{"type": "report"}},
+		{name: "any", match: HeadersMatchAny, headers: amqp.Table{"type": "report", "format": "pdf"}},
+		{name: "unknown match", match: "some", headers: amqp.Table{"type": "report"}, wantErr: true},
+		{name: "invalid header value", match: HeadersMatchAll, headers: amqp.Table{"n": uint64(1)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bo, err := HeadersBinding(tt.match, tt.headers)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("HeadersBinding() = %v, want error", bo.Args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HeadersBinding() error: %v", err)
+			}
+			if bo.Args["x-match"] != tt.match || len(bo.Args) != len(tt.headers)+1 {
+				t.Errorf("HeadersBinding() args = %v", bo.Args)
+			}
+			if _, ok := tt.headers["x-match"]; ok {
+				t.Errorf("headers are modified")
+			}
+		})
+	}
+}
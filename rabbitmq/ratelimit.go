@@ -0,0 +1,176 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type LimitPolicy string
+
+const (
+	// Wait until a token is available
+	LimitPolicyBlock LimitPolicy = "block"
+	// Return a *RateLimitError immediately
+	LimitPolicyFailFast LimitPolicy = "fail_fast"
+)
+
+const (
+	RateLimitScopeProducer = "producer"
+	RateLimitScopeExchange = "exchange"
+	RateLimitScopeGlobal   = "global"
+)
+
+// RateLimit configures a token bucket, Rate <= 0 means unlimited
+type RateLimit struct {
+	// Only used by Config.ExchangeRateLimits
+	Exchange string `mapstructure:"exchange"`
+
+	// Only used by Config.ProducerRateLimits, see Producer.UseRateLimit
+	Producer string `mapstructure:"producer"`
+
+	// Tokens per second
+	Rate float64 `mapstructure:"rate"`
+
+	// Bucket size, at least 1
+	Burst int `mapstructure:"burst"`
+
+	Policy LimitPolicy `mapstructure:"policy"`
+}
+
+// RateLimitConfig is the rabbitmq.rate_limit section of application.yml
+type RateLimitConfig struct {
+	Global    RateLimit   `mapstructure:"global"`
+	Exchanges []RateLimit `mapstructure:"exchanges"`
+	Producers []RateLimit `mapstructure:"producers"`
+}
+
+// RateLimitError is returned when a fail fast limit has no token left
+type RateLimitError struct {
+	Scope string
+	Name  string
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("rabbitmq: %s publish rate limit exceeded", e.Scope)
+	}
+	return fmt.Sprintf("rabbitmq: %s %s publish rate limit exceeded", e.Scope, e.Name)
+}
+
+func IsRateLimited(err error) bool {
+	_, ok := err.(*RateLimitError)
+	return ok
+}
+
+//token bucket
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	policy LimitPolicy
+	scope  string
+	name   string
+
+	//测试时替换成假的时钟
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+//rate <= 0时返回nil, nil limiter不做限制
+func newRateLimiter(rl RateLimit, scope, name string) *rateLimiter {
+	if rl.Rate <= 0 {
+		return nil
+	}
+	burst := float64(rl.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	policy := rl.Policy
+	if policy != LimitPolicyFailFast {
+		policy = LimitPolicyBlock
+	}
+	return &rateLimiter{
+		rate:   rl.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+		policy: policy,
+		scope:  scope,
+		name:   name,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+//no-lock
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+func (l *rateLimiter) wait() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	l.refill(l.now())
+	if l.tokens >= 1 {
+		l.tokens--
+		l.mu.Unlock()
+		return nil
+	}
+	if l.policy == LimitPolicyFailFast {
+		l.mu.Unlock()
+		atomic.AddUint64(&metrics.ThrottleRejected, 1)
+		return &RateLimitError{Scope: l.scope, Name: l.name}
+	}
+	//预定一个token, tokens可以为负数, 后来者等待更久
+	l.tokens--
+	d := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	atomic.AddUint64(&metrics.Throttled, 1)
+	l.sleep(d)
+	return nil
+}
+
+// SetExchangeRateLimit limits the publish rate of every producer of the exchange,
+// a Rate <= 0 removes the limit
+func SetExchangeRateLimit(rl RateLimit) {
+	pool.limitMu.Lock()
+	defer pool.limitMu.Unlock()
+	if l := newRateLimiter(rl, RateLimitScopeExchange, rl.Exchange); l != nil {
+		pool.exchangeLimiters[rl.Exchange] = l
+	} else {
+		delete(pool.exchangeLimiters, rl.Exchange)
+	}
+}
+
+//InitPool时读取的producer限制, 没有配置时ok为false
+func producerRateLimit(name string) (rl RateLimit, ok bool) {
+	if pool == nil {
+		return
+	}
+	pool.limitMu.RLock()
+	defer pool.limitMu.RUnlock()
+	rl, ok = pool.producerLimits[name]
+	return
+}
+
+//先检查exchange的限制再检查全局限制
+func (pool *Pool) allowPublish(exchange string) error {
+	pool.limitMu.RLock()
+	el := pool.exchangeLimiters[exchange]
+	gl := pool.limiter
+	pool.limitMu.RUnlock()
+	if err := el.wait(); err != nil {
+		return err
+	}
+	return gl.wait()
+}
@@ -0,0 +1,161 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"github.com/spf13/viper"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 手动推进的时钟, sleep只记录时长
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept = append(c.slept, d)
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter(rl RateLimit, clock *fakeClock) *rateLimiter {
+	l := newRateLimiter(rl, RateLimitScopeProducer, "test")
+	if l != nil {
+		l.now = clock.Now
+		l.sleep = clock.Sleep
+		l.last = clock.now
+		l.tokens = l.burst
+	}
+	return l
+}
+
+func TestRateLimiter(t *testing.T) {
+	type step struct {
+		// time passed before the call
+		advance   time.Duration
+		wantErr   bool
+		wantSleep time.Duration
+	}
+	tests := []struct {
+		name  string
+		limit RateLimit
+		steps []step
+	}{
+		{
+			name:  "unlimited",
+			limit: RateLimit{Rate: 0, Policy: LimitPolicyFailFast},
+			steps: []step{{}, {}, {}},
+		},
+		{
+			name:  "burst then fail fast",
+			limit: RateLimit{Rate: 1, Burst: 3, Policy: LimitPolicyFailFast},
+			steps: []step{{}, {}, {}, {wantErr: true}},
+		},
+		{
+			name:  "burst below 1",
+			limit: RateLimit{Rate: 1, Burst: 0, Policy: LimitPolicyFailFast},
+			steps: []step{{}, {wantErr: true}},
+		},
+		{
+			name:  "refill",
+			limit: RateLimit{Rate: 2, Burst: 1, Policy: LimitPolicyFailFast},
+			steps: []step{{}, {advance: 250 * time.Millisecond, wantErr: true}, {advance: 250 * time.Millisecond}, {wantErr: true}},
+		},
+		{
+			name:  "refill capped by burst",
+			limit: RateLimit{Rate: 10, Burst: 2, Policy: LimitPolicyFailFast},
+			steps: []step{{}, {}, {advance: time.Hour}, {}, {wantErr: true}},
+		},
+		{
+			name:  "block waits for the next token",
+			limit: RateLimit{Rate: 2, Burst: 1, Policy: LimitPolicyBlock},
+			steps: []step{{}, {wantSleep: 500 * time.Millisecond}, {advance: 100 * time.Millisecond, wantSleep: 400 * time.Millisecond}},
+		},
+		{
+			name:  "unknown policy blocks",
+			limit: RateLimit{Rate: 4, Burst: 2, Policy: "drop"},
+			steps: []step{{}, {}, {wantSleep: 250 * time.Millisecond}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(0, 0)}
+			l := newTestLimiter(tt.limit, clock)
+			for i, s := range tt.steps {
+				clock.now = clock.now.Add(s.advance)
+				clock.slept = nil
+				err := l.wait()
+				if s.wantErr != (err != nil) {
+					t.Fatalf("step %d: wait() error = %v, want error %v", i, err, s.wantErr)
+				}
+				if err != nil && !IsRateLimited(err) {
+					t.Errorf("step %d: error %v is not a rate limit error", i, err)
+				}
+				var slept time.Duration
+				for _, d := range clock.slept {
+					slept += d
+				}
+				if slept != s.wantSleep {
+					t.Errorf("step %d: slept %s, want %s", i, slept, s.wantSleep)
+				}
+			}
+		})
+	}
+}
+
+// block时并发的调用各自预定一个token, 后来者等待更久
+func TestRateLimiterReservation(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := newTestLimiter(RateLimit{Rate: 10, Burst: 1}, clock)
+	//sleep不推进时钟, 模拟同一时刻的多个调用
+	var slept []time.Duration
+	l.sleep = func(d time.Duration) { slept = append(slept, d) }
+	for i := 0; i < 4; i++ {
+		if err := l.wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	if !reflect.DeepEqual(slept, want) {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	yml := []byte(`
+rate_limit:
+  global:
+    rate: 100
+    burst: 10
+    policy: block
+  exchanges:
+    - exchange: orders
+      rate: 0.5
+      burst: 1
+      policy: fail_fast
+  producers:
+    - producer: report
+      rate: 20
+      policy: fail_fast
+`)
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(yml)); err != nil {
+		t.Fatal(err)
+	}
+	var got RateLimitConfig
+	if err := v.UnmarshalKey("rate_limit", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := RateLimitConfig{
+		Global:    RateLimit{Rate: 100, Burst: 10, Policy: LimitPolicyBlock},
+		Exchanges: []RateLimit{{Exchange: "orders", Rate: 0.5, Burst: 1, Policy: LimitPolicyFailFast}},
+		Producers: []RateLimit{{Producer: "report", Rate: 20, Policy: LimitPolicyFailFast}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,60 @@
+package redisstore
+
+import (
+	redigo "github.com/garyburd/redigo/redis"
+	"RabbitmqConnectionDispatcher/common/cache/redis"
+	"RabbitmqConnectionDispatcher/rabbitmq"
+	"time"
+)
+
+// IdempotencyStore implements rabbitmq.IdempotencyStore, the value of a key is
+// "processing" while claimed and "done" after success
+type IdempotencyStore struct {
+	Prefix string
+}
+
+const (
+	idempotencyProcessing = "processing"
+	idempotencyDone       = "done"
+)
+
+//SET NX和GET在同一个脚本中执行, 两个consumer不会同时claim成功, 也不会在两步之间读到过期的key
+var claimScript = redigo.NewScript(1, `
+if redis.call("set", KEYS[1], ARGV[1], "EX", ARGV[2], "NX") then
+	return 1
+end
+return redis.call("get", KEYS[1])
+`)
+
+func NewIdempotencyStore(prefix string) *IdempotencyStore {
+	return &IdempotencyStore{Prefix: prefix}
+}
+
+// Claim sets the key and reads the current state atomically with a lua script
+func (s *IdempotencyStore) Claim(key string, ttl time.Duration) (rabbitmq.IdempotencyState, error) {
+	c := redis.Pool.Get()
+	defer c.Close()
+	res, err := claimScript.Do(c, s.Prefix+key, idempotencyProcessing, ttlSeconds(ttl))
+	if err != nil {
+		return rabbitmq.IdempotencyNew, err
+	}
+	switch v := res.(type) {
+	case int64:
+		return rabbitmq.IdempotencyNew, nil
+	case []byte:
+		if string(v) == idempotencyDone {
+			return rabbitmq.IdempotencyDone, nil
+		}
+	}
+	return rabbitmq.IdempotencyProcessing, nil
+}
+
+func (s *IdempotencyStore) Done(key string, ttl time.Duration) error {
+	return redis.Set(s.Prefix+key, idempotencyDone, ttlSeconds(ttl))
+}
+
+func (s *IdempotencyStore) Forget(key string) error {
+	return redis.Del(s.Prefix + key)
+}
+
+var _ rabbitmq.IdempotencyStore = (*IdempotencyStore)(nil)
@@ -0,0 +1,35 @@
+package redisstore
+
+import (
+	"RabbitmqConnectionDispatcher/common/cache/redis"
+	"RabbitmqConnectionDispatcher/rabbitmq"
+	"time"
+)
+
+func ttlSeconds(ttl time.Duration) int {
+	seconds := int((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// DeliveryCounter implements rabbitmq.DeliveryCounter with INCR, the count
+// survives a restart of the consumer
+type DeliveryCounter struct {
+	Prefix string
+}
+
+func NewDeliveryCounter(prefix string) *DeliveryCounter {
+	return &DeliveryCounter{Prefix: prefix}
+}
+
+func (c *DeliveryCounter) Incr(id string, ttl time.Duration) (int, error) {
+	return redis.Incr(c.Prefix+id, ttlSeconds(ttl))
+}
+
+func (c *DeliveryCounter) Forget(id string) error {
+	return redis.Del(c.Prefix + id)
+}
+
+var _ rabbitmq.DeliveryCounter = (*DeliveryCounter)(nil)
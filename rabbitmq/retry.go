@@ -0,0 +1,97 @@
+package rabbitmq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+var ErrLockTimeout = errors.New("cas acquire lock failed")
+
+// RetryPolicy controls how a failed publish is retried.
+// Every retry takes a fresh channel, the failed one is closed.
+type RetryPolicy struct {
+	// Total number of attempts including the first one, <= 1 disables retry
+	MaxAttempts int
+
+	// Backoff of the n-th retry is BaseBackoff * 2^(n-1), capped by MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// Fraction of the backoff that is randomized, between 0 and 1
+	Jitter float64
+
+	// Decides which errors are retryable, IsRetryable is used when nil
+	Retryable func(err error) bool
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseBackoff: 100 * time.Millisecond,
+		MaxBackoff:  5 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// IsRetryable reports whether err is a transient failure: closed channel or
+// connection, network error, pool lock timeout, confirm nack or timeout.
+func IsRetryable(err error) bool {
+	switch err {
+	case nil:
+		return false
+	case amqp.ErrClosed, ErrLockTimeout, ErrNacked, ErrConfirmTimeout, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	if e, ok := err.(*amqp.Error); ok {
+		switch e.Code {
+		case amqp.ConnectionForced, amqp.ChannelError, amqp.ResourceError, amqp.InternalError:
+			return true
+		}
+		return false
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return false
+}
+
+//nil policy表示不重试
+func (rp *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if rp == nil || err == nil || attempt >= rp.MaxAttempts {
+		return false
+	}
+	if rp.Retryable != nil {
+		return rp.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+//attempt从1开始
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	return backoff(rp.BaseBackoff, rp.MaxBackoff, rp.Jitter, attempt)
+}
+
+func backoff(base, max time.Duration, jitter float64, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base
+	for i := 1; i < attempt && (max <= 0 || d < max); i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	if jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		delta := float64(d) * jitter
+		d = time.Duration(float64(d) - delta + rand.Float64()*2*delta)
+	}
+	return d
+}
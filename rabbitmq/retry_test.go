@@ -0,0 +1,39 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    time.Duration
+		max     time.Duration
+		jitter  float64
+		attempt int
+		min     time.Duration
+		want    time.Duration
+	}{
+		{name: "no base", base: 0, max: time.Second, attempt: 3, want: 0},
+		{name: "first attempt", base: 100 * time.Millisecond, attempt: 1, want: 100 * time.Millisecond},
+		{name: "doubles", base: 100 * time.Millisecond, attempt: 4, want: 800 * time.Millisecond},
+		{name: "capped", base: 100 * time.Millisecond, max: time.Second, attempt: 10, want: time.Second},
+		{name: "no overflow", base: time.Second, max: time.Minute, attempt: 1000, want: time.Minute},
+		{name: "jitter", base: time.Second, jitter: 0.5, attempt: 1, min: 500 * time.Millisecond, want: 1500 * time.Millisecond},
+		{name: "jitter above 1", base: time.Second, jitter: 3, attempt: 1, min: 0, want: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := backoff(tt.base, tt.max, tt.jitter, tt.attempt)
+				if tt.jitter == 0 && got != tt.want {
+					t.Fatalf("backoff() = %s, want %s", got, tt.want)
+				}
+				if tt.jitter > 0 && (got < tt.min || got > tt.want) {
+					t.Fatalf("backoff() = %s, want between %s and %s", got, tt.min, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderError    = "x-error"
+	HeaderFailedAt = "x-failed-at"
+	HeaderAttempts = "x-attempts"
+)
+
+// RetryTopology declares retry queues with escalating TTLs and a final dead
+// letter queue next to the consumer queue. A failed message is published into
+// the retry tier of its attempt, it returns to the consumer queue once the TTL
+// expires. Messages over MaxAttempts, or failed with Reject, are parked in the
+// dead letter queue with the error in the x-error header.
+type RetryTopology struct {
+	// TTL of each retry tier, e.g. 5s, 30s, 5m. The last tier is reused by later attempts
+	Delays []time.Duration
+
+	// Deliveries before a message is dead-lettered, including the first one.
+	// Defaults to len(Delays) + 1
+	MaxAttempts int
+}
+
+//只对ConsumeFunc注册的handler生效, Requeue包装的错误仍然直接重新入队
+func (c *Consumer) SetRetryTopology(rt RetryTopology) {
+	if rt.MaxAttempts <= 0 {
+		rt.MaxAttempts = len(rt.Delays) + 1
+	}
+	c.retryTopology = &rt
+}
+
+func retryQueueName(queue string, delay time.Duration) string {
+	return queue + ".retry." + strconv.FormatInt(int64(delay/time.Millisecond), 10)
+}
+
+func deadLetterQueueName(queue string) string {
+	return queue + ".dlq"
+}
+
+func (rt *RetryTopology) queues(q Queue) []Queue {
+	queues := make([]Queue, 0, len(rt.Delays)+1)
+	for _, d := range rt.Delays {
+		queues = append(queues, Queue{
+			Name:    retryQueueName(q.Name, d),
+			Durable: q.Durable,
+			Args: amqp.Table{
+				"x-message-ttl":             int64(d / time.Millisecond),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": q.Name,
+			},
+		})
+	}
+	queues = append(queues, Queue{
+		Name:    deadLetterQueueName(q.Name),
+		Durable: q.Durable,
+	})
+	return queues
+}
+
+func (rt *RetryTopology) declare(ch *Channel, q Queue) error {
+	for _, rq := range rt.queues(q) {
+		if err := ch.declareQueue(rq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//消息在retry queue中过期的次数, 即已经失败的次数
+func (rt *RetryTopology) retries(q Queue, delivery amqp.Delivery) int {
+	names := make(map[string]bool, len(rt.Delays))
+	for _, d := range rt.Delays {
+		names[retryQueueName(q.Name, d)] = true
+	}
+	deaths, _ := delivery.Headers["x-death"].([]interface{})
+	n := 0
+	for _, death := range deaths {
+		t, ok := death.(amqp.Table)
+		if !ok {
+			continue
+		}
+		if queue, _ := t["queue"].(string); names[queue] {
+			if count, ok := headerInt(t, "count"); ok {
+				n += int(count)
+			}
+		}
+	}
+	return n
+}
+
+//把失败的消息发送到对应的retry queue或者dead letter queue, 成功后原消息可以ack
+func (rt *RetryTopology) route(q Queue, delivery amqp.Delivery, cause error) error {
+	attempt := rt.retries(q, delivery) + 1
+	msg := deliveryToPublishing(delivery)
+	msg.Headers[HeaderAttempts] = int32(attempt)
+	if IsReject(cause) || attempt >= rt.MaxAttempts || len(rt.Delays) == 0 {
+		msg.Headers[HeaderError] = cause.Error()
+		msg.Headers[HeaderFailedAt] = time.Now()
+		return publishOnce("", deadLetterQueueName(q.Name), msg)
+	}
+	tier := attempt - 1
+	if tier >= len(rt.Delays) {
+		tier = len(rt.Delays) - 1
+	}
+	return publishOnce("", retryQueueName(q.Name, rt.Delays[tier]), msg)
+}
+
+//复制delivery的属性和header, 用于重新发布
+func deliveryToPublishing(delivery amqp.Delivery) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         copyTable(delivery.Headers),
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    delivery.DeliveryMode,
+		Priority:        delivery.Priority,
+		CorrelationId:   delivery.CorrelationId,
+		ReplyTo:         delivery.ReplyTo,
+		Expiration:      delivery.Expiration,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		UserId:          delivery.UserId,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	}
+}
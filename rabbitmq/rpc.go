@@ -0,0 +1,247 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	directReplyTo = "amq.rabbitmq.reply-to"
+
+	// Set on a reply when the server handler returned an error
+	HeaderRPCError = "x-rpc-error"
+)
+
+var ErrRPCClientClosed = errors.New("rpc client closed")
+
+// RPCError is returned by Call when the server handler failed
+type RPCError struct {
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return "rpc server error: " + e.Message
+}
+
+//thread-safe
+//默认使用direct reply-to, PrivateReplyQueue为true时使用一个exclusive的私有reply queue
+type RPCClient struct {
+	// Must be set before the first Call
+	PrivateReplyQueue bool
+
+	producer *Producer
+	mu       sync.Mutex
+	conn     *Connection
+	channel  *Channel
+	replyTo  string
+	pending  map[string]chan amqp.Delivery
+	closed   bool
+}
+
+func NewRPCClient() *RPCClient {
+	return &RPCClient{
+		producer: NewSafeProducer(Exchange{}, BindingOptions{}, "rpc-client-"+newMessageID()),
+		pending:  make(map[string]chan amqp.Delivery),
+	}
+}
+
+// Call publishes body and waits for the reply until ctx is done.
+// The deadline of ctx is also used as the expiration of the request.
+func (rc *RPCClient) Call(ctx context.Context, exchange, routingKey string, body []byte) ([]byte, error) {
+	if err := pool.allowPublish(exchange); err != nil {
+		return nil, err
+	}
+	id := newMessageID()
+	reply := make(chan amqp.Delivery, 1)
+	ch, replyTo, err := rc.register(id, reply)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.unregister(id)
+
+	msg := amqp.Publishing{
+		ContentType:   "text/plain",
+		CorrelationId: id,
+		ReplyTo:       replyTo,
+		Body:          body,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := int64(time.Until(deadline) / time.Millisecond)
+		if ms <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		msg.Expiration = strconv.FormatInt(ms, 10)
+	}
+	err = ch.publish(Exchange{Name: exchange}, routingKey, msg)
+	countPublish(err)
+	if err != nil {
+		rc.reset(ch)
+		return nil, err
+	}
+
+	select {
+	case d, ok := <-reply:
+		if !ok {
+			return nil, amqp.ErrClosed
+		}
+		if e, ok := d.Headers[HeaderRPCError].(string); ok {
+			return d.Body, &RPCError{Message: e}
+		}
+		return d.Body, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (rc *RPCClient) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.closed = true
+	if rc.channel != nil {
+		ch, conn := rc.channel, rc.conn
+		rc.channel, rc.conn = nil, nil
+		return conn.discard(ch.tag, MQTypeProducer)
+	}
+	return nil
+}
+
+func (rc *RPCClient) register(id string, reply chan amqp.Delivery) (*Channel, string, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return nil, "", ErrRPCClientClosed
+	}
+	if err := rc.ensureChannel(); err != nil {
+		return nil, "", err
+	}
+	rc.pending[id] = reply
+	return rc.channel, rc.replyTo, nil
+}
+
+func (rc *RPCClient) unregister(id string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.pending, id)
+}
+
+//no-lock
+//direct reply-to要求在同一个channel上以no-ack模式consume之后再publish
+func (rc *RPCClient) ensureChannel() error {
+	if rc.channel != nil {
+		return nil
+	}
+	conn, ch, err := rc.producer.acquire()
+	if err != nil { return err }
+	if err := conn.occupied(ch, true, true); err != nil {
+		ch.close(MQTypeProducer, nil)
+		return err
+	}
+	replyTo := directReplyTo
+	if rc.PrivateReplyQueue {
+		q, err := ch.channel.QueueDeclare("", false, true, true, false, nil)
+		if err != nil {
+			conn.discard(ch.tag, MQTypeProducer)
+			return err
+		}
+		replyTo = q.Name
+	}
+	replies, err := ch.channel.Consume(replyTo, "", true, rc.PrivateReplyQueue, false, false, nil)
+	if err != nil {
+		conn.discard(ch.tag, MQTypeProducer)
+		return err
+	}
+	rc.conn = conn
+	rc.channel = ch
+	rc.replyTo = replyTo
+	go rc.dispatch(ch, replies)
+	return nil
+}
+
+func (rc *RPCClient) dispatch(ch *Channel, replies <-chan amqp.Delivery) {
+	for d := range replies {
+		rc.mu.Lock()
+		reply := rc.pending[d.CorrelationId]
+		delete(rc.pending, d.CorrelationId)
+		rc.mu.Unlock()
+		if reply != nil {
+			reply <- d
+		} else {
+			log.Logger.Info("rpc client drop reply with unknown correlation id ", d.CorrelationId)
+		}
+	}
+	//channel已经关闭, 等待中的调用全部失败
+	rc.reset(ch)
+}
+
+//channel失效后丢弃, 下次Call会重新获取
+func (rc *RPCClient) reset(ch *Channel) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.channel != ch {
+		return
+	}
+	for id, reply := range rc.pending {
+		close(reply)
+		delete(rc.pending, id)
+	}
+	conn := rc.conn
+	rc.channel, rc.conn = nil, nil
+	if err := conn.discard(ch.tag, MQTypeProducer); err != nil {
+		log.Logger.Error("rpc client discard channel error: ", err.Error())
+	}
+}
+
+// RPCHandler returns the body of the reply, a non nil error is sent back
+// in the x-rpc-error header and returned by RPCClient.Call as *RPCError
+type RPCHandler func(ctx context.Context, delivery amqp.Delivery) ([]byte, error)
+
+type RPCServer struct {
+	consumer *Consumer
+}
+
+func NewRPCServer(e Exchange, q Queue, bo BindingOptions, co ConsumerOptions, tag string) *RPCServer {
+	return &RPCServer{
+		consumer: NewConsumer(e, q, bo, co, tag),
+	}
+}
+
+// Consumer gives access to qos, reconnection and close settings
+func (s *RPCServer) Consumer() *Consumer {
+	return s.consumer
+}
+
+// Serve blocks like Consumer.Consume
+func (s *RPCServer) Serve(handler RPCHandler) error {
+	autoAck := s.consumer.session.ConsumerOptions.AutoAck
+	return s.consumer.Consume(func(delivery amqp.Delivery) {
+		body, err := handler(context.Background(), delivery)
+		if delivery.ReplyTo != "" {
+			if err1 := s.reply(delivery, body, err); err1 != nil {
+				log.Logger.Error("rpc server reply ", delivery.CorrelationId, " error: ", err1.Error())
+			}
+		}
+		if !autoAck {
+			if err1 := delivery.Ack(false); err1 != nil {
+				log.Logger.Error(err1.Error())
+			}
+		}
+	})
+}
+
+//回复发到默认exchange, publishOnce每次使用不同的key, 避免并发回复时争用同一个channel
+func (s *RPCServer) reply(delivery amqp.Delivery, body []byte, err error) error {
+	msg := amqp.Publishing{
+		ContentType:   delivery.ContentType,
+		CorrelationId: delivery.CorrelationId,
+		Body:          body,
+	}
+	if err != nil {
+		msg.Headers = amqp.Table{HeaderRPCError: err.Error()}
+	}
+	return publishOnce("", delivery.ReplyTo, msg)
+}
@@ -0,0 +1,62 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"RabbitmqConnectionDispatcher/common/log"
+	"strings"
+)
+
+// ShutdownConsumers stops every consuming consumer: the broker stops delivering,
+// in-flight handlers finish, then the consumer is shut down
+func ShutdownConsumers(ctx context.Context) error {
+	consumersMu.RLock()
+	list := make([]*Consumer, 0, len(consumers))
+	for _, c := range consumers {
+		list = append(list, c)
+	}
+	consumersMu.RUnlock()
+
+	done := make(chan error, len(list))
+	for _, c := range list {
+		go func(c *Consumer) {
+			if err := drainConsumer(c); err != nil {
+				done <- fmt.Errorf("consumer %s: %s", c.Tag, err.Error())
+				return
+			}
+			done <- nil
+		}(c)
+	}
+	var errs []string
+	for range list {
+		select {
+		case err := <-done:
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ClosePool closes every connection of the pool, producers must not be used afterwards
+func ClosePool() error {
+	if pool == nil {
+		return nil
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for tag, conn := range pool.connections {
+		if conn.connection != nil {
+			conn.close()
+		}
+		delete(pool.connections, tag)
+	}
+	log.Logger.Info("rabbitmq connections pool closed")
+	return nil
+}
@@ -0,0 +1,128 @@
+package rabbitmq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"sync/atomic"
+	"time"
+)
+
+type ConsumerState int32
+
+const (
+	ConsumerStopped ConsumerState = iota
+	ConsumerRunning
+	ConsumerReconnecting
+	ConsumerPaused
+)
+
+func (s ConsumerState) String() string {
+	switch s {
+	case ConsumerStopped:
+		return "stopped"
+	case ConsumerRunning:
+		return "running"
+	case ConsumerReconnecting:
+		return "reconnecting"
+	case ConsumerPaused:
+		return "paused"
+	}
+	return "unknown"
+}
+
+var ErrReconnectGaveUp = errors.New("consumer gave up reconnecting")
+
+type reconnectPolicy struct {
+	base       time.Duration
+	max        time.Duration
+	retryTimes int
+}
+
+func (c *Consumer) State() ConsumerState {
+	return ConsumerState(atomic.LoadInt32(&c.state))
+}
+
+func (c *Consumer) setState(s ConsumerState) {
+	atomic.StoreInt32(&c.state, int32(s))
+}
+
+func (c *Consumer) isStopping() bool {
+	return atomic.LoadInt32(&c.stopping) == 1
+}
+
+//Consume开始时调用, 返回本次消费使用的stopCh
+func (c *Consumer) resetStop() <-chan struct{} {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+	atomic.StoreInt32(&c.stopping, 0)
+	c.stopCh = make(chan struct{})
+	return c.stopCh
+}
+
+//Shutdown/Close时调用, 让supervisor不再重连
+func (c *Consumer) stop() {
+	c.stopMu.Lock()
+	if atomic.CompareAndSwapInt32(&c.stopping, 0, 1) && c.stopCh != nil {
+		close(c.stopCh)
+	}
+	c.stopMu.Unlock()
+	c.abortPause()
+}
+
+//每次失败后都会以相同的handler和qos重新声明topology并恢复消费, 重连间隔指数增长
+//消费成功开始后失败次数清零
+func (c *Consumer) supervise(handler func(delivery amqp.Delivery), stopCh <-chan struct{}) error {
+	failures := 0
+	for {
+		c.setState(ConsumerRunning)
+		err := c.consume(handler)
+		if c.isStopping() {
+			c.setState(ConsumerStopped)
+			return err
+		}
+		if err != nil {
+			log.Logger.Error("consumer ", c.Tag, " error: ", err.Error())
+			failures++
+		} else {
+			//deliveries被关闭, 说明之前已经在正常消费
+			failures = 1
+		}
+		c.release()
+
+		if c.reconnect.retryTimes != FOREVER && failures > c.reconnect.retryTimes {
+			c.setState(ConsumerStopped)
+			log.Logger.Info("give up retry connect ", c.session.BindingOptions.RoutingKey)
+			return ErrReconnectGaveUp
+		}
+		c.setState(ConsumerReconnecting)
+		d := backoff(c.reconnect.base, c.reconnect.max, 0.2, failures)
+		log.Logger.Info("retry connect ", c.session.BindingOptions.RoutingKey, " ", failures, " times after ", d)
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-stopCh:
+			t.Stop()
+			c.setState(ConsumerStopped)
+			return nil
+		}
+	}
+}
+
+//丢弃失效的channel, 并清空topology缓存保证重连后重新声明
+func (c *Consumer) release() {
+	ch := c.channel
+	if ch == nil {
+		return
+	}
+	c.channel = nil
+	ch.topology.reset()
+	pool.mu.RLock()
+	conn := pool.connections[ch.connTag]
+	pool.mu.RUnlock()
+	if conn != nil {
+		conn.discardChannel(ch, MQTypeConsumer)
+	} else {
+		ch.close(MQTypeConsumer, nil)
+	}
+}
@@ -0,0 +1,51 @@
+package rabbitmq
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConsumerStop(t *testing.T) {
+	c := NewConsumer(Exchange{}, Queue{}, BindingOptions{}, ConsumerOptions{}, "test")
+	//Consume之前stop不会panic
+	c.stop()
+	if !c.isStopping() {
+		t.Fatal("consumer is not stopping")
+	}
+
+	stopCh := c.resetStop()
+	if c.isStopping() {
+		t.Fatal("consumer is still stopping after reset")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.stop()
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-stopCh:
+	default:
+		t.Fatal("stopCh is not closed")
+	}
+
+	//并发的重置和stop, 用go test -race检查
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		var ch <-chan struct{}
+		go func() {
+			defer wg.Done()
+			ch = c.resetStop()
+		}()
+		go func() {
+			defer wg.Done()
+			c.stop()
+		}()
+		wg.Wait()
+		c.stop()
+		<-ch
+	}
+}
@@ -0,0 +1,327 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+)
+
+const (
+	BindingDestinationQueue    = "queue"
+	BindingDestinationExchange = "exchange"
+)
+
+// TopologyConfig is the rabbitmq.topology section of application.yml
+type TopologyConfig struct {
+	Exchanges []ExchangeConfig
+	Queues    []QueueConfig
+	Bindings  []BindingConfig
+}
+
+type ExchangeConfig struct {
+	Name       string
+	Type       string
+	Durable    bool
+	AutoDelete bool `mapstructure:"auto_delete"`
+	Internal   bool
+	Args       map[string]interface{}
+}
+
+type QueueConfig struct {
+	Name       string
+	Durable    bool
+	AutoDelete bool `mapstructure:"auto_delete"`
+	Exclusive  bool
+	Args       map[string]interface{}
+}
+
+// BindingConfig binds the source exchange to a queue or, when
+// DestinationType is "exchange", to another exchange
+type BindingConfig struct {
+	Source          string
+	Destination     string
+	DestinationType string `mapstructure:"destination_type"`
+	RoutingKey      string `mapstructure:"routing_key"`
+	Args            map[string]interface{}
+}
+
+// Drift is a difference between the topology and the broker found by Verify
+type Drift struct {
+	Kind   string
+	Name   string
+	Reason string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s: %s", d.Kind, d.Name, d.Reason)
+}
+
+//配置中声明的exchange, queue和binding, 按名字查找
+type Topology struct {
+	exchanges map[string]Exchange
+	queues    map[string]Queue
+	bindings  []BindingConfig
+	//保持配置中的顺序
+	exchangeOrder []string
+	queueOrder    []string
+}
+
+func NewTopology(tc TopologyConfig) (*Topology, error) {
+	t := &Topology{
+		exchanges: make(map[string]Exchange),
+		queues:    make(map[string]Queue),
+	}
+	for _, ec := range tc.Exchanges {
+		if ec.Name == "" {
+			return nil, fmt.Errorf("topology: exchange name is empty")
+		}
+		if _, ok := t.exchanges[ec.Name]; ok {
+			return nil, fmt.Errorf("topology: duplicate exchange %s", ec.Name)
+		}
+		if ec.Type == "" {
+			ec.Type = amqp.ExchangeDirect
+		}
+		t.exchanges[ec.Name] = Exchange{
+			Name:       ec.Name,
+			Type:       ec.Type,
+			Durable:    ec.Durable,
+			AutoDelete: ec.AutoDelete,
+			Internal:   ec.Internal,
+			Args:       configTable(ec.Args),
+		}
+		t.exchangeOrder = append(t.exchangeOrder, ec.Name)
+	}
+	for _, qc := range tc.Queues {
+		if qc.Name == "" {
+			return nil, fmt.Errorf("topology: queue name is empty")
+		}
+		if _, ok := t.queues[qc.Name]; ok {
+			return nil, fmt.Errorf("topology: duplicate queue %s", qc.Name)
+		}
+		t.queues[qc.Name] = Queue{
+			Name:       qc.Name,
+			Durable:    qc.Durable,
+			AutoDelete: qc.AutoDelete,
+			Exclusive:  qc.Exclusive,
+			Args:       configTable(qc.Args),
+		}
+		t.queueOrder = append(t.queueOrder, qc.Name)
+	}
+	for _, bc := range tc.Bindings {
+		if _, ok := t.exchanges[bc.Source]; !ok {
+			return nil, fmt.Errorf("topology: binding source exchange %s is not defined", bc.Source)
+		}
+		switch bc.DestinationType {
+		case "", BindingDestinationQueue:
+			bc.DestinationType = BindingDestinationQueue
+			if _, ok := t.queues[bc.Destination]; !ok {
+				return nil, fmt.Errorf("topology: binding destination queue %s is not defined", bc.Destination)
+			}
+		case BindingDestinationExchange:
+			if _, ok := t.exchanges[bc.Destination]; !ok {
+				return nil, fmt.Errorf("topology: binding destination exchange %s is not defined", bc.Destination)
+			}
+		default:
+			return nil, fmt.Errorf("topology: unknown binding destination type %s", bc.DestinationType)
+		}
+		t.bindings = append(t.bindings, bc)
+	}
+	return t, nil
+}
+
+//yaml解码出的整数是int, 嵌套的map是map[interface{}]interface{}, 转换成amqp.Table支持的类型
+func configTable(m map[string]interface{}) amqp.Table {
+	if len(m) == 0 {
+		return nil
+	}
+	t := make(amqp.Table, len(m))
+	for k, v := range m {
+		t[k] = configValue(v)
+	}
+	return t
+}
+
+func configValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case map[string]interface{}:
+		return configTable(v)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = e
+		}
+		return configTable(m)
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = configValue(e)
+		}
+		return a
+	}
+	return v
+}
+
+func (t *Topology) Exchange(name string) (Exchange, bool) {
+	e, ok := t.exchanges[name]
+	return e, ok
+}
+
+func (t *Topology) Queue(name string) (Queue, bool) {
+	q, ok := t.queues[name]
+	return q, ok
+}
+
+// Declare declares every exchange, queue and binding of the topology
+func (t *Topology) Declare() error {
+	return borrowChannel(func(ch *Channel) error {
+		for _, name := range t.exchangeOrder {
+			if err := ch.declareExchange(t.exchanges[name]); err != nil {
+				return fmt.Errorf("declare exchange %s: %s", name, err.Error())
+			}
+		}
+		for _, name := range t.queueOrder {
+			if err := ch.declareQueue(t.queues[name]); err != nil {
+				return fmt.Errorf("declare queue %s: %s", name, err.Error())
+			}
+		}
+		for _, bc := range t.bindings {
+			if err := t.bind(ch, bc); err != nil {
+				return fmt.Errorf("bind %s to %s %s: %s", bc.Source, bc.DestinationType, bc.Destination, err.Error())
+			}
+		}
+		return nil
+	})
+}
+
+func (t *Topology) bind(ch *Channel, bc BindingConfig) error {
+	source := t.exchanges[bc.Source]
+	if bc.DestinationType == BindingDestinationExchange {
+		return ch.bindExchange(t.exchanges[bc.Destination], ExchangeBinding{
+			Source:     source,
+			RoutingKey: bc.RoutingKey,
+			Args:       configTable(bc.Args),
+		})
+	}
+	return ch.bindQueue(t.queues[bc.Destination], source, BindingOptions{
+		RoutingKey: bc.RoutingKey,
+		Args:       configTable(bc.Args),
+	})
+}
+
+// Verify checks that the exchanges and queues exist using passive declares only,
+// so the broker is never changed. A missing entity is reported as a Drift.
+// Passive declares do not compare arguments and bindings can not be inspected
+// through AMQP, neither is verified.
+func (t *Topology) Verify() ([]Drift, error) {
+	var drifts []Drift
+	for _, name := range t.exchangeOrder {
+		e := t.exchanges[name]
+		drift, err := verify(func(ch *amqp.Channel) error {
+			return ch.ExchangeDeclarePassive(e.Name, e.Type, e.Durable, e.AutoDelete, e.Internal, false, e.Args)
+		})
+		if err != nil {
+			return drifts, err
+		}
+		if drift != "" {
+			drifts = append(drifts, Drift{Kind: "exchange", Name: name, Reason: drift})
+		}
+	}
+	for _, name := range t.queueOrder {
+		q := t.queues[name]
+		drift, err := verify(func(ch *amqp.Channel) error {
+			_, err := ch.QueueDeclarePassive(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.Args)
+			return err
+		})
+		if err != nil {
+			return drifts, err
+		}
+		if drift != "" {
+			drifts = append(drifts, Drift{Kind: "queue", Name: name, Reason: drift})
+		}
+	}
+	for _, d := range drifts {
+		log.Logger.Error("topology drift, ", d.String())
+	}
+	return drifts, nil
+}
+
+//404表示drift, 其他错误直接返回
+//异常会关闭channel, 所以每个entity都使用新借用的channel
+func verify(exists func(ch *amqp.Channel) error) (string, error) {
+	var drift string
+	err := borrowChannel(func(ch *Channel) error {
+		err := exists(ch.channel)
+		if e, ok := err.(*amqp.Error); ok {
+			switch e.Code {
+			case amqp.NotFound:
+				drift = "not found"
+				//channel已经被broker关闭, 返回错误让它被丢弃
+				return errDrift
+			case amqp.ResourceLocked:
+				//其他connection的exclusive queue, 存在即可
+				return errDrift
+			}
+		}
+		return err
+	})
+	if err == errDrift {
+		return drift, nil
+	}
+	return drift, err
+}
+
+var errDrift = fmt.Errorf("topology drift")
+
+// NewConsumer builds a consumer of a queue of the topology, the exchange and the
+// routing keys come from the bindings of the queue
+func (t *Topology) NewConsumer(queue string, co ConsumerOptions, tag string) (*Consumer, error) {
+	q, ok := t.queues[queue]
+	if !ok {
+		return nil, fmt.Errorf("topology: queue %s is not defined", queue)
+	}
+	var c *Consumer
+	for _, bc := range t.bindings {
+		if bc.DestinationType != BindingDestinationQueue || bc.Destination != queue {
+			continue
+		}
+		bo := BindingOptions{RoutingKey: bc.RoutingKey, Args: configTable(bc.Args)}
+		if c == nil {
+			c = NewConsumer(t.exchanges[bc.Source], q, bo, co, tag)
+		} else if bc.Source == c.session.Exchange.Name {
+			c.AddBinding(bo)
+		}
+		//其他exchange的binding由Declare负责
+	}
+	if c == nil {
+		return nil, fmt.Errorf("topology: queue %s has no binding", queue)
+	}
+	return c, nil
+}
+
+// NewProducer builds a thread-safe producer of an exchange of the topology
+func (t *Topology) NewProducer(exchange, routingKey, unique string) (*Producer, error) {
+	e, ok := t.exchanges[exchange]
+	if !ok {
+		return nil, fmt.Errorf("topology: exchange %s is not defined", exchange)
+	}
+	return NewSafeProducer(e, BindingOptions{RoutingKey: routingKey}, unique), nil
+}
+
+//从pool借用一个channel执行fn, fn返回错误时channel被丢弃
+func borrowChannel(fn func(ch *Channel) error) error {
+	p := NewSafeProducer(Exchange{}, BindingOptions{}, "borrow"+newMessageID())
+	p.skipDeclare = true
+	conn, ch, err := p.acquire()
+	if err != nil { return err }
+	if err := conn.occupied(ch, true, true); err != nil {
+		ch.close(MQTypeProducer, nil)
+		return err
+	}
+	if err := fn(ch); err != nil {
+		conn.discardChannel(ch, MQTypeProducer)
+		return err
+	}
+	return p.Shutdown(conn)
+}
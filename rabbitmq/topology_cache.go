@@ -0,0 +1,142 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"sync"
+)
+
+//每个connection一份, 记录已经声明成功的exchange, queue和binding, 避免每次publish/consume都重复声明
+//connection关闭或者channel异常关闭时清空
+type topologyCache struct {
+	mu       sync.RWMutex
+	declared map[string]struct{}
+}
+
+func newTopologyCache() *topologyCache {
+	return &topologyCache{
+		declared: make(map[string]struct{}),
+	}
+}
+
+func (tc *topologyCache) known(key string) bool {
+	if tc == nil { return false }
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	_, ok := tc.declared[key]
+	return ok
+}
+
+func (tc *topologyCache) remember(key string) {
+	if tc == nil { return }
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.declared[key] = struct{}{}
+}
+
+func (tc *topologyCache) reset() {
+	if tc == nil { return }
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.declared = make(map[string]struct{})
+}
+
+//key包含全部声明参数, 参数不同的声明不会命中缓存
+func exchangeKey(e Exchange) string {
+	return fmt.Sprintf("exchange|%s|%s|%t|%t|%t|%v", e.Name, e.Type, e.Durable, e.AutoDelete, e.Internal, e.Args)
+}
+
+func queueKey(q Queue) string {
+	return fmt.Sprintf("queue|%s|%t|%t|%t|%v", q.Name, q.Durable, q.AutoDelete, q.Exclusive, q.Args)
+}
+
+func bindingKey(queue, exchange string, bo BindingOptions) string {
+	return fmt.Sprintf("binding|%s|%s|%s|%v", queue, exchange, bo.RoutingKey, bo.Args)
+}
+
+func (ch *Channel) declareExchange(e Exchange) error {
+	//默认exchange不需要也不允许声明
+	if e.Name == "" { return nil }
+	key := exchangeKey(e)
+	if ch.topology.known(key) { return nil }
+	if err := ch.channel.ExchangeDeclare(
+		e.Name,       // name of the exchange
+		e.Type,       // type
+		e.Durable,    // durable
+		e.AutoDelete, // delete when complete
+		e.Internal,   // internal
+		e.NoWait,     // noWait
+		e.Args,       // arguments
+	); err != nil {
+		return err
+	}
+	//auto delete的exchange随时可能被删除, 不缓存
+	if !e.AutoDelete {
+		ch.topology.remember(key)
+	}
+	return nil
+}
+
+func (ch *Channel) declareQueue(q Queue) error {
+	key := queueKey(q)
+	if q.Name != "" && ch.topology.known(key) { return nil }
+	if _, err := ch.channel.QueueDeclare(
+		q.Name,       // name of the queue
+		q.Durable,    // durable
+		q.AutoDelete, // delete when usused
+		q.Exclusive,  // exclusive
+		q.NoWait,     // noWait
+		q.Args,       // arguments
+	); err != nil {
+		return err
+	}
+	//由服务器生成名字的queue和auto delete的queue不缓存
+	if q.Name != "" && !q.AutoDelete {
+		ch.topology.remember(key)
+	}
+	return nil
+}
+
+func (ch *Channel) bindQueue(q Queue, e Exchange, bo BindingOptions) error {
+	key := bindingKey(q.Name, e.Name, bo)
+	if q.Name != "" && !q.AutoDelete && ch.topology.known(key) { return nil }
+	if err := ch.channel.QueueBind(
+		// bind to real queue
+		q.Name,        // name of the queue
+		bo.RoutingKey, // bindingKey
+		e.Name,        // sourceExchange
+		bo.NoWait,     // noWait
+		bo.Args,       // arguments
+	); err != nil {
+		return err
+	}
+	if q.Name != "" && !q.AutoDelete && !e.AutoDelete {
+		ch.topology.remember(key)
+	}
+	return nil
+}
+
+func exchangeBindingKey(destination string, eb ExchangeBinding) string {
+	return fmt.Sprintf("exchange-binding|%s|%s|%s|%v", destination, eb.Source.Name, eb.RoutingKey, eb.Args)
+}
+
+//把source exchange绑定到destination exchange, source会先被声明
+func (ch *Channel) bindExchange(destination Exchange, eb ExchangeBinding) error {
+	if err := ch.declareExchange(eb.Source); err != nil {
+		return err
+	}
+	key := exchangeBindingKey(destination.Name, eb)
+	if ch.topology.known(key) { return nil }
+	if err := ch.channel.ExchangeBind(
+		destination.Name, // destination
+		eb.RoutingKey,    // routing key
+		eb.Source.Name,   // source
+		eb.NoWait,        // noWait
+		eb.Args,          // arguments
+	); err != nil {
+		return err
+	}
+	if !destination.AutoDelete && !eb.Source.AutoDelete {
+		ch.topology.remember(key)
+	}
+	return nil
+}
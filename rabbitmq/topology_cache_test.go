@@ -0,0 +1,102 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestTopologyKeys(t *testing.T) {
+	e := Exchange{Name: "e", Type: "topic", Durable: true, Args: amqp.Table{"a": "1", "b": "2"}}
+	q := Queue{Name: "q", Durable: true, Args: amqp.Table{"x-max-length": int64(10)}}
+	bo := BindingOptions{RoutingKey: "rk", Args: amqp.Table{"x-match": "all"}}
+	eb := ExchangeBinding{Source: Exchange{Name: "src"}, RoutingKey: "rk"}
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{name: "same exchange", a: exchangeKey(e), b: exchangeKey(Exchange{Name: "e", Type: "topic", Durable: true, Args: amqp.Table{"b": "2", "a": "1"}}), equal: true},
+		{name: "exchange no wait ignored", a: exchangeKey(e), b: exchangeKey(func() Exchange { e := e; e.NoWait = true; return e }()), equal: true},
+		{name: "exchange type", a: exchangeKey(e), b: exchangeKey(func() Exchange { e := e; e.Type = "direct"; return e }())},
+		{name: "exchange durable", a: exchangeKey(e), b: exchangeKey(func() Exchange { e := e; e.Durable = false; return e }())},
+		{name: "exchange internal", a: exchangeKey(e), b: exchangeKey(func() Exchange { e := e; e.Internal = true; return e }())},
+		{name: "exchange args", a: exchangeKey(e), b: exchangeKey(func() Exchange { e := e; e.Args = amqp.Table{"a": "1"}; return e }())},
+		{name: "same queue", a: queueKey(q), b: queueKey(Queue{Name: "q", Durable: true, Args: amqp.Table{"x-max-length": int64(10)}}), equal: true},
+		{name: "queue name", a: queueKey(q), b: queueKey(func() Queue { q := q; q.Name = "q2"; return q }())},
+		{name: "queue exclusive", a: queueKey(q), b: queueKey(func() Queue { q := q; q.Exclusive = true; return q }())},
+		{name: "queue args", a: queueKey(q), b: queueKey(func() Queue { q := q; q.Args = amqp.Table{"x-max-length": int64(11)}; return q }())},
+		{name: "exchange and queue of the same name", a: exchangeKey(Exchange{Name: "x"}), b: queueKey(Queue{Name: "x"})},
+		{name: "same binding", a: bindingKey("q", "e", bo), b: bindingKey("q", "e", BindingOptions{RoutingKey: "rk", NoWait: true, Args: amqp.Table{"x-match": "all"}}), equal: true},
+		{name: "binding routing key", a: bindingKey("q", "e", bo), b: bindingKey("q", "e", BindingOptions{RoutingKey: "rk2", Args: bo.Args})},
+		{name: "binding headers", a: bindingKey("q", "e", bo), b: bindingKey("q", "e", BindingOptions{RoutingKey: "rk", Args: amqp.Table{"x-match": "any"}})},
+		{name: "binding exchange", a: bindingKey("q", "e", bo), b: bindingKey("q", "e2", bo)},
+		{name: "queue binding and exchange binding", a: bindingKey("dst", "src", BindingOptions{RoutingKey: "rk"}), b: exchangeBindingKey("dst", eb)},
+		{name: "exchange binding source", a: exchangeBindingKey("dst", eb), b: exchangeBindingKey("dst", ExchangeBinding{Source: Exchange{Name: "src2"}, RoutingKey: "rk"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.a == tt.b) != tt.equal {
+				t.Errorf("key %q and %q, want equal %v", tt.a, tt.b, tt.equal)
+			}
+		})
+	}
+}
+
+func TestTopologyCache(t *testing.T) {
+	var nilCache *topologyCache
+	nilCache.remember("k")
+	nilCache.reset()
+	if nilCache.known("k") {
+		t.Errorf("nil cache knows k")
+	}
+
+	tc := newTopologyCache()
+	if tc.known("k") {
+		t.Fatalf("empty cache knows k")
+	}
+	tc.remember("k")
+	if !tc.known("k") || tc.known("other") {
+		t.Errorf("known(k) = %v, known(other) = %v", tc.known("k"), tc.known("other"))
+	}
+	tc.reset()
+	if tc.known("k") {
+		t.Errorf("cache knows k after reset")
+	}
+}
+
+// 命中缓存或者不需要声明时不会使用amqp channel, channel为nil时调用会panic
+func TestTopologyCacheSkipsDeclare(t *testing.T) {
+	e := Exchange{Name: "e", Type: "direct", Durable: true}
+	q := Queue{Name: "q", Durable: true}
+	bo := BindingOptions{RoutingKey: "rk"}
+	eb := ExchangeBinding{Source: Exchange{Name: "src", Type: "fanout"}}
+	ch := &Channel{topology: newTopologyCache()}
+	ch.topology.remember(exchangeKey(e))
+	ch.topology.remember(queueKey(q))
+	ch.topology.remember(bindingKey(q.Name, e.Name, bo))
+	ch.topology.remember(exchangeKey(eb.Source))
+	ch.topology.remember(exchangeBindingKey(e.Name, eb))
+
+	tests := []struct {
+		name    string
+		declare func() error
+	}{
+		{name: "default exchange", declare: func() error { return ch.declareExchange(Exchange{}) }},
+		{name: "exchange", declare: func() error { return ch.declareExchange(e) }},
+		{name: "queue", declare: func() error { return ch.declareQueue(q) }},
+		{name: "binding", declare: func() error { return ch.bindQueue(q, e, bo) }},
+		{name: "exchange binding", declare: func() error { return ch.bindExchange(e, eb) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("declared on the channel: %v", r)
+				}
+			}()
+			if err := tt.declare(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
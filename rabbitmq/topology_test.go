@@ -0,0 +1,45 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+	"reflect"
+	"testing"
+)
+
+func TestConfigTable(t *testing.T) {
+	tests := []struct {
+		name string
+		in   map[string]interface{}
+		want amqp.Table
+	}{
+		{name: "empty", in: nil, want: nil},
+		{
+			name: "yaml values",
+			in: map[string]interface{}{
+				"x-max-length": 10,
+				"x-queue-type": "quorum",
+				"x-lazy":       true,
+				"nested":       map[interface{}]interface{}{"a": 1, 2: "b"},
+				"list":         []interface{}{1, "c"},
+			},
+			want: amqp.Table{
+				"x-max-length": int64(10),
+				"x-queue-type": "quorum",
+				"x-lazy":       true,
+				"nested":       amqp.Table{"a": int64(1), "2": "b"},
+				"list":         []interface{}{int64(1), "c"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := configTable(tt.in)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("configTable() = %v, want %v", got, tt.want)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("configTable() is not a valid amqp.Table: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"RabbitmqConnectionDispatcher/common/log"
+	"sync/atomic"
+)
+
+// PublishTx publishes inside an AMQP transaction, see Producer.Tx
+type PublishTx interface {
+	// Publish with the routing key of the producer binding
+	Publish(body []byte) error
+	PublishWith(routingKey string, msg amqp.Publishing) error
+}
+
+type publishTx struct {
+	producer  *Producer
+	channel   *Channel
+	published uint64
+}
+
+func (tx *publishTx) Publish(body []byte) error {
+	return tx.PublishWith(tx.producer.session.BindingOptions.RoutingKey, amqp.Publishing{
+		ContentType: "text/plain",
+		Body: body,
+	})
+}
+
+func (tx *publishTx) PublishWith(routingKey string, msg amqp.Publishing) error {
+	if msg.MessageId == "" {
+		msg.MessageId = newMessageID()
+	}
+	msg, err := compressPublishing(tx.producer.compressor, tx.producer.compressThreshold, msg)
+	if err != nil {
+		return err
+	}
+	if err := tx.producer.limiter.wait(); err != nil {
+		return err
+	}
+	if err := pool.allowPublish(tx.producer.session.Exchange.Name); err != nil {
+		return err
+	}
+	if err := tx.channel.publish(tx.producer.session.Exchange, routingKey, msg); err != nil {
+		return err
+	}
+	tx.published++
+	return nil
+}
+
+//在事务模式的channel上执行fn, fn返回nil时commit, 返回错误或者panic时rollback
+//tx模式的channel和confirm模式不能混用, 所以放在connection单独的txChannels池中
+func (p *Producer) Tx(fn func(tx PublishTx) error) (err error) {
+	conn, ch, err := p.acquireTx()
+	if err != nil {
+		return err
+	}
+	tx := &publishTx{producer: p, channel: ch}
+	defer func() {
+		if r := recover(); r != nil {
+			if err1 := ch.channel.TxRollback(); err1 != nil {
+				log.Logger.Error("tx rollback error: ", err1.Error())
+			}
+			conn.releaseTxChannel(ch, false)
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if err1 := ch.channel.TxRollback(); err1 != nil {
+			log.Logger.Error("tx rollback error: ", err1.Error())
+			conn.releaseTxChannel(ch, false)
+			return err
+		}
+		conn.releaseTxChannel(ch, true)
+		return err
+	}
+	if err = ch.channel.TxCommit(); err != nil {
+		conn.releaseTxChannel(ch, false)
+		atomic.AddUint64(&metrics.PublishErrors, tx.published)
+		return err
+	}
+	conn.releaseTxChannel(ch, true)
+	atomic.AddUint64(&metrics.Published, tx.published)
+	return nil
+}
+
+func (p *Producer) acquireTx() (*Connection, *Channel, error) {
+	pool.mu.RLock()
+	conn := pool.chooseIdleConnection(MQTypeProducer)
+	pool.mu.RUnlock()
+	if conn == nil {
+		if pool.reachMaxConnection() {
+			return nil, nil, fmt.Errorf("Maximum number of connections reached")
+		}
+		conns, err := pool.createNewConn(MQTypeProducer, true)
+		if err != nil { return nil, nil, err }
+		conn = conns
+	}
+
+	ch := conn.chooseIdleTxChannel()
+	if ch == nil {
+		newCh, err := conn.createNewProducerChannel()
+		if err != nil { return nil, nil, err }
+		if err := newCh.channel.Tx(); err != nil {
+			newCh.close(MQTypeProducer, nil)
+			return nil, nil, err
+		}
+		ch = newCh
+	}
+	if err := p.bind(ch); err != nil {
+		ch.close(MQTypeProducer, nil)
+		return nil, nil, err
+	}
+	return conn, ch, nil
+}
+
+func (conn *Connection) chooseIdleTxChannel() *Channel {
+	err := lock(&conn.lock)
+	defer func() {
+		conn.lock = 0
+	}()
+	if err != nil {
+		log.Logger.Error("connection ", conn.tag, " ", err.Error())
+		return nil
+	}
+	if len(conn.txChannels) > 0 {
+		ch := conn.txChannels[0]
+		conn.txChannels = conn.txChannels[1:]
+		return ch
+	}
+	return nil
+}
+
+//reuse为false或者tx池已满时关闭channel
+func (conn *Connection) releaseTxChannel(ch *Channel, reuse bool) {
+	if reuse {
+		if err := lock(&conn.lock); err == nil {
+			if conn.connection != nil && len(conn.txChannels) < MAX_PRODUCER_CHANNEL_PER_CONN {
+				ch.clean(MQTypeProducer, nil)
+				conn.txChannels = append(conn.txChannels, ch)
+				conn.lock = 0
+				return
+			}
+			conn.lock = 0
+		}
+	}
+	ch.close(MQTypeProducer, nil)
+}
@@ -5,7 +5,9 @@ import (
 	"RabbitmqConnectionDispatcher/common/cache/redis"
 	"RabbitmqConnectionDispatcher/common/log"
 	"RabbitmqConnectionDispatcher/common/queue"
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +26,11 @@ type dispatchChunk struct {
 
 var center WorkerCenter
 var dispatcher chan(*dispatchChunk)
+var stopping int32
+//已分发还未处理完的任务和运行中的worker, Shutdown等待它们完成
+var inflight sync.WaitGroup
+//保证Shutdown开始等待后不再有新的任务进入inflight
+var dispatchMu sync.RWMutex
 func init() {
 	center = WorkerCenter{}
 	dispatcher = make(chan *dispatchChunk, 1000)
@@ -47,6 +54,14 @@ type WorkerCenter struct {
 }
 
 func DispatchSerial(device string, t WorkType, extra interface{}) {
+	dispatchMu.RLock()
+	if atomic.LoadInt32(&stopping) == 1 {
+		dispatchMu.RUnlock()
+		log.Logger.Info("worker center is stopping, discard work of device " + device)
+		return
+	}
+	inflight.Add(1)
+	dispatchMu.RUnlock()
 	dispatcher <- &dispatchChunk{
 		id: device,
 		ct: t,
@@ -74,10 +89,39 @@ func listenerDispatch() {
 					}
 				}
 			}
+			inflight.Done()
 		}
 	}()
 }
 
+//不再接收新的任务, 等待已分发的任务和所有worker的任务完成
+func Shutdown(ctx context.Context) error {
+	dispatchMu.Lock()
+	atomic.StoreInt32(&stopping, 1)
+	dispatchMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		log.Logger.Info("worker center stop timeout, ", center.count(), " workers left")
+		return ctx.Err()
+	}
+}
+
+func (wc *WorkerCenter) count() int {
+	n := 0
+	wc.Workers.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
 type MissionType int
 const (
 	MissionTypeSyncONE        = 1 << 0
@@ -266,7 +310,9 @@ func (w *Worker) createMissionQueue() {
 
 func (w *Worker) startWork() {
 	//在子线程处理同步逻辑
+	inflight.Add(1)
 	go func() {
+		defer inflight.Done()
 		log.Logger.Info("worker " + w.Id + " start work on goroutine ", common.GetGoroutineID())
 		t := time.NewTicker(1 * time.Second)
 		defer t.Stop()